@@ -3,10 +3,15 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"net"
 	"os"
+	"os/signal"
 	goruntime "runtime"
+	"strings"
+	"syscall"
+	"time"
 
 	"github.com/spf13/pflag"
 	"sigs.k8s.io/controller-runtime/pkg/log/zap"
@@ -26,15 +31,128 @@ func init() {
 }
 
 func main() {
+	os.Exit(run())
+}
+
+// collectorFlags are the command-line flags which select and tune collectors,
+// common to server and dump modes
+type collectorFlags struct {
+	noProfile         bool
+	profileTopClients int
+	profileCounters   bool
+	localVNNOnly      bool
+	winbind           bool
+	winbindProbeName  string
+	shareFS           bool
+	processStats      bool
+	quota             bool
+	quotaMaxUsers     int
+}
+
+func (cf *collectorFlags) register(flags *pflag.FlagSet) {
+	flags.BoolVar(&cf.noProfile, "no-profile", false,
+		"Run without collecting profile information")
+	flags.IntVar(&cf.profileTopClients, "profile-top-clients", 0,
+		"Export only per-client totals of top N clients of per-share profile")
+	flags.BoolVar(&cf.profileCounters, "profile-counters", false,
+		"Export profile request and operation totals as counters (not gauges)")
+	flags.BoolVar(&cf.localVNNOnly, "local-vnn-only", false,
+		"Count only sessions, tree-connections and open files of local CTDB node")
+	flags.BoolVar(&cf.winbind, "winbind", false,
+		"Collect health of winbindd and its domain controller connection")
+	flags.StringVar(&cf.winbindProbeName, "winbind-probe-name", "",
+		"Account name used for winbind lookup latency probes")
+	flags.BoolVar(&cf.shareFS, "share-fs", false,
+		"Collect capacity of file-systems which host shares")
+	flags.BoolVar(&cf.processStats, "process-stats", false,
+		"Collect resource usage of smbd processes serving clients")
+	flags.BoolVar(&cf.quota, "quota", false,
+		"Collect per-user quota usage of shares")
+	flags.IntVar(&cf.quotaMaxUsers, "quota-max-users", metrics.DefaultQuotaMaxUsers,
+		"Maximal number of users per share to export quota usage for")
+}
+
+func (cf *collectorFlags) config() *metrics.ExporterConfig {
+	return &metrics.ExporterConfig{
+		Profile:           !cf.noProfile,
+		ProfileTopClients: cf.profileTopClients,
+		ProfileCounters:   cf.profileCounters,
+		LocalVNNOnly:      cf.localVNNOnly,
+		Winbind:           cf.winbind,
+		WinbindProbeName:  cf.winbindProbeName,
+		ShareFS:           cf.shareFS,
+		ProcessStats:      cf.processStats,
+		Quota:             cf.quota,
+		QuotaMaxUsers:     cf.quotaMaxUsers,
+	}
+}
+
+func run() int {
+	if len(os.Args) > 1 && os.Args[1] == "dump" {
+		return runDump(os.Args[2:])
+	}
+	if len(os.Args) > 1 && os.Args[1] == "top" {
+		return runTop(os.Args[2:])
+	}
+	var cf collectorFlags
+	cf.register(pflag.CommandLine)
 	var port int
 	pflag.IntVar(&port, "port", metrics.DefaultMetricsPort,
 		"Prometheus metrics-exporter port number")
-	var bindAddress net.IP
-	pflag.IPVar(&bindAddress, "address", bindAddress,
-		"Prometheus metrics-exporter bind address")
-	var noProfile bool
-	pflag.BoolVar(&noProfile, "no-profile", false,
-		"Run without collecting profile information")
+	var bindAddrs []net.IP
+	pflag.IPSliceVar(&bindAddrs, "address", bindAddrs,
+		"Prometheus metrics-exporter bind address (may be repeated)")
+	var unixSocket string
+	pflag.StringVar(&unixSocket, "unix-socket", "",
+		"Prometheus metrics-exporter Unix domain socket path")
+	var profileDerived bool
+	pflag.BoolVar(&profileDerived, "profile-derived", false,
+		"Export rates and average latencies derived from profile information")
+	var smbdAddress string
+	pflag.StringVar(&smbdAddress, "smbd-address", metrics.DefaultSmbdAddress,
+		"Address of smbd used by readiness probe")
+	var shutdownTimeout time.Duration
+	pflag.DurationVar(&shutdownTimeout, "shutdown-timeout",
+		metrics.DefaultShutdownTimeout,
+		"Grace period for in-flight requests upon termination")
+	var auditLog string
+	pflag.StringVar(&auditLog, "audit-log", "",
+		"Path of Samba JSON audit log file to follow")
+	var auditMaxRemoteAddresses int
+	pflag.IntVar(&auditMaxRemoteAddresses, "audit-max-remote-addresses",
+		metrics.DefaultAuditMaxRemoteAddresses,
+		"Maximal number of remote addresses to export authentication events for")
+	var smbdLogs []string
+	pflag.StringArrayVar(&smbdLogs, "smbd-log", smbdLogs,
+		"Path or glob pattern of smbd log files to follow (may be repeated)")
+	var tailFromStart bool
+	pflag.BoolVar(&tailFromStart, "tail-from-start", false,
+		"Process followed log files from their beginning")
+	var otlpEndpoint string
+	pflag.StringVar(&otlpEndpoint, "otlp-endpoint", "",
+		"URL of OpenTelemetry collector to push metrics to")
+	var otlpProtocol string
+	pflag.StringVar(&otlpProtocol, "otlp-protocol", metrics.OTLPProtocolGRPC,
+		"Transport protocol of OTLP push ('grpc' or 'http')")
+	var otlpInterval time.Duration
+	pflag.DurationVar(&otlpInterval, "otlp-interval", metrics.DefaultOTLPInterval,
+		"Interval between consecutive pushes of metrics to OTLP endpoint")
+	var pushgatewayURL string
+	pflag.StringVar(&pushgatewayURL, "pushgateway-url", "",
+		"URL of Prometheus Pushgateway to push metrics to")
+	var remoteWriteURL string
+	pflag.StringVar(&remoteWriteURL, "remote-write-url", "",
+		"URL of Prometheus remote-write endpoint to push metrics to")
+	var pushInterval time.Duration
+	pflag.DurationVar(&pushInterval, "push-interval", metrics.DefaultPushInterval,
+		"Interval between consecutive pushes to Pushgateway or remote-write")
+	var textfileDir string
+	pflag.StringVar(&textfileDir, "textfile-dir", "",
+		"Directory to periodically write metrics into, instead of serving HTTP")
+	var textfileInterval time.Duration
+	pflag.DurationVar(&textfileInterval, "textfile-interval",
+		metrics.DefaultTextfileInterval,
+		"Interval between consecutive writes of metrics into textfile directory")
 	var showVersions bool
 	pflag.BoolVar(&showVersions, "show-versions", false,
 		"Show versions info and exit")
@@ -44,12 +162,16 @@ func main() {
 		showVersionsAndExit()
 	}
 
+	ctx, stop := signal.NotifyContext(context.Background(),
+		os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
 	log := zap.New(zap.UseDevMode(true))
 	log.Info("Initializing smbmetrics",
 		"ProgramName", os.Args[0],
 		"GoVersion", goruntime.Version())
 
-	vers, _ := metrics.ResolveVersions(nil)
+	vers, _ := metrics.ResolveVersions(ctx, nil)
 	log.Info("Versions", "Versions", vers)
 
 	podid := metrics.GetSelfPodID()
@@ -60,28 +182,122 @@ func main() {
 	loc, err := metrics.LocateSMBStatus()
 	if err != nil {
 		log.Error(err, "Failed to locate smbstatus")
-		os.Exit(1)
+		return 1
 	}
-	ver, err := metrics.RunSMBStatusVersion()
+	ver, err := metrics.RunSMBStatusVersion(ctx)
 	if err != nil {
 		log.Error(err, "Failed to run smbstatus")
-		os.Exit(1)
+		return 1
 	}
 	log.Info("Located smbstatus", "path", loc, "version", ver)
 
-	var bindAddrs []net.IP
-	if len(bindAddress) > 0 {
-		bindAddrs = append(bindAddrs, bindAddress)
+	if len(bindAddrs) > 0 {
 		log.Info("User supplied bind addresses", "bindAddrs", bindAddrs)
 	}
-	err = metrics.RunSmbMetricsExporter(log, port, bindAddrs, !noProfile)
+	if len(unixSocket) > 0 {
+		log.Info("User supplied unix socket", "unixSocket", unixSocket)
+	}
+	if len(auditLog) > 0 {
+		log.Info("User supplied audit log", "auditLog", auditLog)
+	}
+	if len(smbdLogs) > 0 {
+		log.Info("User supplied smbd logs", "smbdLogs", smbdLogs)
+	}
+	if len(otlpEndpoint) > 0 {
+		log.Info("User supplied OTLP endpoint",
+			"otlpEndpoint", otlpEndpoint, "otlpProtocol", otlpProtocol)
+	}
+	if len(pushgatewayURL) > 0 {
+		log.Info("User supplied Pushgateway", "pushgatewayURL", pushgatewayURL)
+	}
+	if len(remoteWriteURL) > 0 {
+		log.Info("User supplied remote-write", "remoteWriteURL", remoteWriteURL)
+	}
+	if len(textfileDir) > 0 {
+		log.Info("User supplied textfile directory", "textfileDir", textfileDir)
+	}
+	cfg := cf.config()
+	cfg.Port = port
+	cfg.BindAddresses = bindAddrs
+	cfg.UnixSocket = unixSocket
+	cfg.ProfileDerived = profileDerived
+	cfg.SmbdAddress = smbdAddress
+	cfg.ShutdownTimeout = shutdownTimeout
+	cfg.AuditLog = auditLog
+	cfg.AuditMaxRemoteAddresses = auditMaxRemoteAddresses
+	cfg.SmbdLogs = smbdLogs
+	cfg.TailFromStart = tailFromStart
+	cfg.OTLPEndpoint = otlpEndpoint
+	cfg.OTLPProtocol = otlpProtocol
+	cfg.OTLPInterval = otlpInterval
+	cfg.PushgatewayURL = pushgatewayURL
+	cfg.RemoteWriteURL = remoteWriteURL
+	cfg.PushInterval = pushInterval
+	cfg.TextfileDir = textfileDir
+	cfg.TextfileInterval = textfileInterval
+	err = metrics.RunSmbMetricsExporter(ctx, log, cfg)
+	if err != nil {
+		return 1
+	}
+	log.Info("Terminated smbmetrics")
+	return 0
+}
+
+// runDump executes all collectors once and prints the gathered metrics to
+// standard output; logs go to standard error
+func runDump(args []string) int {
+	flags := pflag.NewFlagSet("dump", pflag.ExitOnError)
+	var cf collectorFlags
+	cf.register(flags)
+	var format string
+	flags.StringVar(&format, "format", metrics.DumpFormatText,
+		"Output format ("+strings.Join(metrics.DumpFormats, ", ")+")")
+	_ = flags.Parse(args)
+
+	ctx, stop := signal.NotifyContext(context.Background(),
+		os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	log := zap.New(zap.UseDevMode(true), zap.WriteTo(os.Stderr))
+	err := metrics.DumpSmbMetrics(ctx, log, cf.config(), format, os.Stdout)
+	if err != nil {
+		log.Error(err, "Failed to dump metrics")
+		return 1
+	}
+	return 0
+}
+
+// runTop shows a periodically refreshed table of per-share and per-client
+// activity on standard output
+func runTop(args []string) int {
+	flags := pflag.NewFlagSet("top", pflag.ExitOnError)
+	cfg := metrics.TopConfig{}
+	flags.DurationVar(&cfg.Interval, "interval", metrics.DefaultTopInterval,
+		"Refresh interval")
+	flags.StringVar(&cfg.SortBy, "sort", metrics.SMBTopSortBytes,
+		"Sort order ("+strings.Join(metrics.SMBTopSortKeys, ", ")+")")
+	flags.IntVar(&cfg.Limit, "limit", 0,
+		"Show only top N entries")
+	flags.IntVar(&cfg.Iterations, "iterations", 0,
+		"Exit after N refreshes")
+	flags.BoolVar(&cfg.Batch, "batch", false,
+		"Do not clear screen between refreshes")
+	_ = flags.Parse(args)
+
+	ctx, stop := signal.NotifyContext(context.Background(),
+		os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	err := metrics.RunSMBTop(ctx, &cfg, os.Stdout)
 	if err != nil {
-		os.Exit(1)
+		fmt.Fprintln(os.Stderr, "Error:", err)
+		return 1
 	}
+	return 0
 }
 
 func showVersionsAndExit() {
-	vers, _ := metrics.ResolveVersions(nil)
+	vers, _ := metrics.ResolveVersions(context.Background(), nil)
 	fmt.Println("Progname:", os.Args[0])
 	fmt.Println("Version:", vers.Version)
 	fmt.Println("CommitID:", vers.CommitID)
@@ -0,0 +1,108 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package metrics
+
+import (
+	"encoding/json"
+	"net/http"
+)
+
+var (
+	// DefaultAPIPath is the default HTTP path prefix of JSON status API
+	DefaultAPIPath = "/api/v1/"
+)
+
+func (sme *smbMetricsExporter) registerAPIHandlers() {
+	sme.mux.HandleFunc(DefaultAPIPath+"sessions", sme.serveSessions)
+	sme.mux.HandleFunc(DefaultAPIPath+"shares", sme.serveShares)
+	sme.mux.HandleFunc(DefaultAPIPath+"openfiles", sme.serveOpenFiles)
+	sme.mux.HandleFunc(DefaultAPIPath+"profile", sme.serveProfile)
+}
+
+func (sme *smbMetricsExporter) serveSessions(w http.ResponseWriter, r *http.Request) {
+	if !checkAPIMethod(w, r) {
+		return
+	}
+	smbInfo, err := NewUpdatedSMBInfo(r.Context(), sme.log)
+	if err != nil {
+		serveAPIError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	serveAPIResult(w, smbInfo.FilterSessions(apiFilter(r)))
+}
+
+func (sme *smbMetricsExporter) serveShares(w http.ResponseWriter, r *http.Request) {
+	if !checkAPIMethod(w, r) {
+		return
+	}
+	smbInfo, err := NewUpdatedSMBInfo(r.Context(), sme.log)
+	if err != nil {
+		serveAPIError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	serveAPIResult(w, smbInfo.FilterTreeCons(apiFilter(r)))
+}
+
+func (sme *smbMetricsExporter) serveOpenFiles(w http.ResponseWriter, r *http.Request) {
+	if !checkAPIMethod(w, r) {
+		return
+	}
+	smbInfo, err := NewUpdatedSMBInfo(r.Context(), sme.log)
+	if err != nil {
+		serveAPIError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	err = smbInfo.UpdateOpenFiles(r.Context())
+	if err != nil {
+		serveAPIError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	serveAPIResult(w, smbInfo.FilterOpenFiles(apiFilter(r)))
+}
+
+func (sme *smbMetricsExporter) serveProfile(w http.ResponseWriter, r *http.Request) {
+	if !checkAPIMethod(w, r) {
+		return
+	}
+	if !sme.cfg.Profile {
+		serveAPIError(w, http.StatusNotFound, "profile collection is disabled")
+		return
+	}
+	smbProfileInfo, err := NewUpdatedSMBProfileInfo(r.Context(), sme.log)
+	if err != nil {
+		serveAPIError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	serveAPIResult(w, smbProfileInfo.FilterProfile(apiFilter(r)))
+}
+
+func apiFilter(r *http.Request) *SMBInfoFilter {
+	query := r.URL.Query()
+	return &SMBInfoFilter{
+		Share:   query.Get("share"),
+		Machine: query.Get("machine"),
+		User:    query.Get("user"),
+	}
+}
+
+func checkAPIMethod(w http.ResponseWriter, r *http.Request) bool {
+	if r.Method == http.MethodGet || r.Method == http.MethodHead {
+		return true
+	}
+	w.Header().Set("Allow", "GET, HEAD")
+	serveAPIError(w, http.StatusMethodNotAllowed, "method not allowed")
+	return false
+}
+
+func serveAPIResult(w http.ResponseWriter, result any) {
+	w.Header().Set("Content-Type", "application/json")
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	_ = enc.Encode(result)
+}
+
+func serveAPIError(w http.ResponseWriter, code int, msg string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	_ = json.NewEncoder(w).Encode(map[string]string{"error": msg})
+}
@@ -0,0 +1,206 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package metrics
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const (
+	// SMBAuditAuthentication is the type of Samba authentication events
+	SMBAuditAuthentication = "Authentication"
+	// SMBAuditAuthorization is the type of Samba authorization events
+	SMBAuditAuthorization = "Authorization"
+	// SMBAuditOtherRemoteAddresses is the remote_address label value which
+	// aggregates authentication events of all remote addresses beyond the
+	// maximal number of tracked ones
+	SMBAuditOtherRemoteAddresses = ProfileOtherClients
+	// DefaultAuditMaxRemoteAddresses is the default maximal number of
+	// distinct remote addresses of authentication events to export
+	DefaultAuditMaxRemoteAddresses = 100
+)
+
+// SMBAuditEvent represents a single Samba JSON audit event, as logged by
+// 'auth_json_audit', 'dsdb_json_audit' and similar debug classes
+type SMBAuditEvent struct {
+	Timestamp string
+	Type      string
+	// Fields holds the type-specific attributes of the event
+	Fields map[string]any
+}
+
+// ParseSMBAuditLine parses a Samba log line which contains a JSON audit
+// event, such as:
+//
+//	JSON Authentication: {"timestamp": ..., "type": "Authentication",
+//	  "Authentication": {...}}
+func ParseSMBAuditLine(line string) (*SMBAuditEvent, error) {
+	start := strings.Index(line, "{")
+	if start < 0 {
+		return nil, errors.New("no JSON object in line")
+	}
+	raw := map[string]json.RawMessage{}
+	if err := json.Unmarshal([]byte(line[start:]), &raw); err != nil {
+		return nil, err
+	}
+	event := &SMBAuditEvent{Fields: map[string]any{}}
+	_ = json.Unmarshal(raw["timestamp"], &event.Timestamp)
+	if err := json.Unmarshal(raw["type"], &event.Type); err != nil {
+		return nil, fmt.Errorf("audit event without type: %w", err)
+	}
+	if dat, found := raw[event.Type]; found {
+		if err := json.Unmarshal(dat, &event.Fields); err != nil {
+			return nil, err
+		}
+	}
+	return event, nil
+}
+
+func (ev *SMBAuditEvent) field(names ...string) string {
+	for _, name := range names {
+		if val, ok := ev.Fields[name].(string); ok && val != "" {
+			return val
+		}
+	}
+	return ""
+}
+
+// Status returns the NT_STATUS (or other) result of the audited operation
+func (ev *SMBAuditEvent) Status() string {
+	return ev.field("status")
+}
+
+// AuthMethod returns the authentication method (such as 'NTLMv2' or
+// 'Kerberos') of an authentication or authorization event
+func (ev *SMBAuditEvent) AuthMethod() string {
+	return ev.field("passwordType", "authType")
+}
+
+// Domain returns the domain of the user who triggered the event
+func (ev *SMBAuditEvent) Domain() string {
+	return ev.field("clientDomain", "domain", "becameDomain")
+}
+
+// RemoteAddress returns the IP address of remote peer, without the address
+// family prefix and port of Samba's 'ipv4:<addr>:<port>' notation
+func (ev *SMBAuditEvent) RemoteAddress() string {
+	addr := ev.field("remoteAddress")
+	for _, prefix := range []string{"ipv4:", "ipv6:"} {
+		if rest, found := strings.CutPrefix(addr, prefix); found {
+			if idx := strings.LastIndex(rest, ":"); idx > 0 {
+				return rest[:idx]
+			}
+			return rest
+		}
+	}
+	return addr
+}
+
+// Operation returns the audited operation (such as 'Modify' or 'openat')
+// of non-authentication events
+func (ev *SMBAuditEvent) Operation() string {
+	return ev.field("operation", "action")
+}
+
+type smbAuditCollector struct {
+	sme         *smbMetricsExporter
+	events      *prometheus.CounterVec
+	auths       *prometheus.CounterVec
+	operations  *prometheus.CounterVec
+	parseErrors prometheus.Counter
+	// remoteAddresses is the set of remote addresses which are exported as
+	// is; accessed only by the follower of audit log
+	remoteAddresses map[string]bool
+}
+
+func (col *smbAuditCollector) Describe(ch chan<- *prometheus.Desc) {
+	col.events.Describe(ch)
+	col.auths.Describe(ch)
+	col.operations.Describe(ch)
+	col.parseErrors.Describe(ch)
+}
+
+func (col *smbAuditCollector) Collect(ch chan<- prometheus.Metric) {
+	col.events.Collect(ch)
+	col.auths.Collect(ch)
+	col.operations.Collect(ch)
+	col.parseErrors.Collect(ch)
+}
+
+func (col *smbAuditCollector) follow(ctx context.Context) {
+	tailer := newFileTailer(col.sme.cfg.AuditLog,
+		col.sme.cfg.TailFromStart, col.sme.log)
+	tailer.run(ctx, col.handleLine)
+}
+
+func (col *smbAuditCollector) handleLine(line string) {
+	// Samba prefixes each JSON event with a debug header line
+	if !strings.Contains(line, "{") {
+		return
+	}
+	event, err := ParseSMBAuditLine(line)
+	if err != nil {
+		col.parseErrors.Inc()
+		return
+	}
+	col.handleEvent(event)
+}
+
+func (col *smbAuditCollector) handleEvent(event *SMBAuditEvent) {
+	col.events.WithLabelValues(event.Type).Inc()
+	switch {
+	case event.Type == SMBAuditAuthentication:
+		col.auths.WithLabelValues(
+			event.Status(),
+			event.AuthMethod(),
+			event.Domain(),
+			col.remoteAddressLabel(event.RemoteAddress())).Inc()
+	case event.Operation() != "":
+		col.operations.WithLabelValues(
+			event.Type,
+			event.Operation(),
+			event.Status()).Inc()
+	}
+}
+
+// remoteAddressLabel returns the remote_address label value of addr, which
+// is SMBAuditOtherRemoteAddresses once the maximal number of distinct
+// addresses has been reached (zero means no limit)
+func (col *smbAuditCollector) remoteAddressLabel(addr string) string {
+	limit := col.sme.cfg.AuditMaxRemoteAddresses
+	if limit <= 0 || col.remoteAddresses[addr] {
+		return addr
+	}
+	if len(col.remoteAddresses) >= limit {
+		return SMBAuditOtherRemoteAddresses
+	}
+	col.remoteAddresses[addr] = true
+	return addr
+}
+
+func (sme *smbMetricsExporter) newSMBAuditCollector() *smbAuditCollector {
+	col := &smbAuditCollector{sme: sme, remoteAddresses: map[string]bool{}}
+	col.events = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: collectorName("audit", "events_total"),
+		Help: "Number of Samba audit events by type",
+	}, []string{"type"})
+	col.auths = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: collectorName("audit", "authentications_total"),
+		Help: "Number of Samba authentication events",
+	}, []string{"status", "auth_method", "domain", "remote_address"})
+	col.operations = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: collectorName("audit", "operations_total"),
+		Help: "Number of audited Samba operations",
+	}, []string{"type", "operation", "status"})
+	col.parseErrors = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: collectorName("audit", "parse_errors_total"),
+		Help: "Number of malformed Samba audit events",
+	})
+	return col
+}
@@ -0,0 +1,82 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package metrics
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/go-logr/logr"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseSMBAuditLine(t *testing.T) {
+	lines := strings.Split(readTestData(t, "audit.log"), "\n")
+	event, err := ParseSMBAuditLine(lines[1])
+	assert.NoError(t, err)
+	assert.Equal(t, event.Type, SMBAuditAuthentication)
+	assert.Equal(t, event.Status(), "NT_STATUS_OK")
+	assert.Equal(t, event.AuthMethod(), "NTLMv2")
+	assert.Equal(t, event.Domain(), "SAMDOM")
+	assert.Equal(t, event.RemoteAddress(), "10.0.0.21")
+	assert.Empty(t, event.Operation())
+
+	event, err = ParseSMBAuditLine(lines[3])
+	assert.NoError(t, err)
+	assert.Equal(t, event.Type, SMBAuditAuthorization)
+	assert.Equal(t, event.AuthMethod(), "NTLMSSP")
+	assert.Equal(t, event.Domain(), "SAMDOM")
+
+	event, err = ParseSMBAuditLine(lines[5])
+	assert.NoError(t, err)
+	assert.Equal(t, event.RemoteAddress(), "fd00::21")
+
+	event, err = ParseSMBAuditLine(lines[7])
+	assert.NoError(t, err)
+	assert.Equal(t, event.Type, "dsdbChange")
+	assert.Equal(t, event.Operation(), "Modify")
+	assert.Equal(t, event.Status(), "Success")
+
+	_, err = ParseSMBAuditLine(lines[8])
+	assert.Error(t, err)
+	_, err = ParseSMBAuditLine(lines[0])
+	assert.Error(t, err)
+}
+
+func TestSMBAuditCollector(t *testing.T) {
+	sme := newSmbMetricsExporter(t.Context(), logr.Discard(), &ExporterConfig{})
+	col := sme.newSMBAuditCollector()
+	for _, line := range strings.Split(readTestData(t, "audit.log"), "\n") {
+		col.handleLine(line)
+	}
+	assert.Equal(t, testutil.ToFloat64(
+		col.events.WithLabelValues(SMBAuditAuthentication)), float64(2))
+	assert.Equal(t, testutil.ToFloat64(
+		col.auths.WithLabelValues(
+			"NT_STATUS_WRONG_PASSWORD", "NTLMv2", "SAMDOM", "fd00::21")),
+		float64(1))
+	assert.Equal(t, testutil.ToFloat64(
+		col.operations.WithLabelValues("dsdbChange", "Modify", "Success")),
+		float64(1))
+	assert.Equal(t, testutil.ToFloat64(col.parseErrors), float64(1))
+}
+
+func TestSMBAuditCollectorMaxRemoteAddresses(t *testing.T) {
+	sme := newSmbMetricsExporter(t.Context(), logr.Discard(),
+		&ExporterConfig{AuditMaxRemoteAddresses: 1})
+	col := sme.newSMBAuditCollector()
+	for _, line := range strings.Split(readTestData(t, "audit.log"), "\n") {
+		col.handleLine(line)
+	}
+	assert.Equal(t, testutil.ToFloat64(
+		col.auths.WithLabelValues(
+			"NT_STATUS_OK", "NTLMv2", "SAMDOM", "10.0.0.21")),
+		float64(1))
+	assert.Equal(t, testutil.ToFloat64(
+		col.auths.WithLabelValues(
+			"NT_STATUS_WRONG_PASSWORD", "NTLMv2", "SAMDOM",
+			SMBAuditOtherRemoteAddresses)),
+		float64(1))
+	assert.Equal(t, testutil.CollectAndCount(col.auths), 2)
+}
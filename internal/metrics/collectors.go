@@ -3,6 +3,8 @@
 package metrics
 
 import (
+	"time"
+
 	"github.com/prometheus/client_golang/prometheus"
 )
 
@@ -15,6 +17,30 @@ func (sme *smbMetricsExporter) register() error {
 		sme.newSMBVersionsCollector(),
 		sme.newSMBStatusCollector(),
 		sme.newSMBProfileCollector(),
+		sme.newSMBCTDBCollector(),
+		sme.newSMBShareConfigCollector(),
+	}
+	if sme.cfg.Winbind {
+		cols = append(cols, sme.newSMBWinbindCollector())
+	}
+	if sme.cfg.ShareFS {
+		cols = append(cols, sme.newSMBShareFSCollector())
+	}
+	if sme.cfg.ProcessStats {
+		cols = append(cols, sme.newSMBProcessStatsCollector())
+	}
+	if sme.cfg.Quota {
+		cols = append(cols, sme.newSMBQuotaCollector())
+	}
+	if sme.cfg.AuditLog != "" {
+		audit := sme.newSMBAuditCollector()
+		cols = append(cols, audit)
+		sme.followers = append(sme.followers, audit)
+	}
+	if len(sme.cfg.SmbdLogs) > 0 {
+		smbdLog := sme.newSMBDLogCollector()
+		cols = append(cols, smbdLog)
+		sme.followers = append(sme.followers, smbdLog)
 	}
 	for _, c := range cols {
 		if err := sme.reg.Register(c); err != nil {
@@ -36,7 +62,7 @@ func (col *smbCollector) Refresh() {
 	if col.netbiosName != "" {
 		return
 	}
-	netbiosName, err := resolveNetbiosName()
+	netbiosName, err := resolveNetbiosName(col.sme.ctx)
 	if err != nil {
 		return
 	}
@@ -57,7 +83,7 @@ type smbVersionsCollector struct {
 func (col *smbVersionsCollector) Collect(ch chan<- prometheus.Metric) {
 	status := 0
 	col.Refresh()
-	vers, err := ResolveVersions(col.clnt)
+	vers, err := ResolveVersions(col.sme.ctx, col.clnt)
 	if err != nil {
 		status = 1
 	}
@@ -99,10 +125,24 @@ type smbStatusCollector struct {
 }
 
 func (col *smbStatusCollector) Collect(ch chan<- prometheus.Metric) {
-	smbInfo, err := NewUpdatedSMBInfo(col.sme.log)
+	smbInfo, err := col.sme.sharedSMBInfo()
+	col.sme.lastCollect.update(err)
 	if err != nil {
 		return
 	}
+	// Open files are only used for per-VNN distribution, which is exported
+	// in clustered mode only; do not fail the entire collection if not
+	// available
+	clustered := col.sme.clustered()
+	if clustered {
+		if err := smbInfo.UpdateOpenFiles(col.sme.ctx); err != nil {
+			col.sme.log.V(1).Info("smbstatus locks failed", "err", err)
+			clustered = false
+		}
+	}
+	if col.sme.cfg.LocalVNNOnly {
+		smbInfo.RestrictToVNN(col.sme.localVNN())
+	}
 	col.Refresh()
 	ch <- prometheus.MustNewConstMetric(col.dsc[0],
 		prometheus.GaugeValue, float64(smbInfo.TotalSessions()))
@@ -127,6 +167,46 @@ func (col *smbStatusCollector) Collect(ch chan<- prometheus.Metric) {
 			float64(len(services)),
 			machine)
 	}
+	col.collectVNNMetrics(ch, smbInfo, clustered)
+}
+
+func (col *smbStatusCollector) collectVNNMetrics(
+	ch chan<- prometheus.Metric, smbInfo *SMBInfo, withOpenFiles bool) {
+	for vnn, count := range smbInfo.MapVNNToSessions() {
+		ch <- prometheus.MustNewConstMetric(col.dsc[5],
+			prometheus.GaugeValue, float64(count), vnn)
+	}
+	for vnn, count := range smbInfo.MapVNNToTreeCons() {
+		ch <- prometheus.MustNewConstMetric(col.dsc[6],
+			prometheus.GaugeValue, float64(count), vnn)
+	}
+	if !withOpenFiles {
+		return
+	}
+	for vnn, count := range smbInfo.MapVNNToOpenFiles() {
+		ch <- prometheus.MustNewConstMetric(col.dsc[7],
+			prometheus.GaugeValue, float64(count), vnn)
+	}
+}
+
+// clustered tells whether Samba runs in clustered (CTDB) mode on host
+func (sme *smbMetricsExporter) clustered() bool {
+	_, err := LocateCTDB()
+	return err == nil
+}
+
+// localVNN resolves the VNN of local CTDB node, or the non-cluster VNN when
+// not running in clustered mode
+func (sme *smbMetricsExporter) localVNN() string {
+	if !sme.clustered() {
+		return NonClusterVNN
+	}
+	pnn, err := RunCTDBPNN(sme.ctx)
+	if err != nil {
+		sme.log.V(1).Info("ctdb pnn failed", "err", err)
+		return NonClusterVNN
+	}
+	return pnn
 }
 
 func (sme *smbMetricsExporter) newSMBStatusCollector() prometheus.Collector {
@@ -157,139 +237,166 @@ func (sme *smbMetricsExporter) newSMBStatusCollector() prometheus.Collector {
 			collectorName("share", "byremote"),
 			"Number of shares served for remote machine",
 			[]string{"machine"}, nil),
+
+		prometheus.NewDesc(
+			collectorName("vnn", "sessions_total"),
+			"Number of currently active SMB sessions per CTDB node",
+			[]string{"vnn"}, nil),
+
+		prometheus.NewDesc(
+			collectorName("vnn", "tcon_total"),
+			"Number of currently active SMB tree-connections per CTDB node",
+			[]string{"vnn"}, nil),
+
+		prometheus.NewDesc(
+			collectorName("vnn", "openfiles_total"),
+			"Number of currently open files per CTDB node",
+			[]string{"vnn"}, nil),
 	}
 	return col
 }
 
+// smbProfileCollector exports the base profile metrics via smbCollector's
+// descriptors; descriptors of optional features are kept by name (and are
+// appended to the base ones for Describe)
 type smbProfileCollector struct {
 	smbCollector
+	deriver        smbProfileDeriver
+	clientTotals   []*prometheus.Desc
+	shareTotals    []*prometheus.Desc
+	extendedInfo   *prometheus.Desc
+	unparsableKeys *prometheus.Desc
+	smb2Rates      []*prometheus.Desc
+	vfsIORates     []*prometheus.Desc
+	smb2Latency    *prometheus.Desc
+	vfsIOLatency   *prometheus.Desc
 }
 
 func (col *smbProfileCollector) Collect(ch chan<- prometheus.Metric) {
-	if !col.sme.profile {
+	if !col.sme.cfg.Profile {
 		return
 	}
-	smbProfileInfo, err := NewUpdatedSMBProfileInfo(col.sme.log)
+	smbProfileInfo, err := NewUpdatedSMBProfileInfo(col.sme.ctx, col.sme.log)
 	if err != nil {
 		return
 	}
 	col.Refresh()
-	smb2Calls := smbProfileInfo.profileStatus.SMB2Calls
-	if smb2Calls != nil {
-		col.collectSMB2CallsMetrics(ch, smb2Calls, "", "")
+	// Profile counters are reset upon smbd restart
+	var created time.Time
+	if col.sme.cfg.ProfileCounters {
+		created = col.sme.smbdStartTime()
+	}
+	col.collectProfileMetrics(ch, smbProfileInfo.profileStatus, created)
+}
+
+func (col *smbProfileCollector) collectProfileMetrics(
+	ch chan<- prometheus.Metric, profile *SMBProfile, created time.Time) {
+	if profile.SMB2Calls != nil {
+		col.collectSMB2CallsMetrics(ch, profile.SMB2Calls, "", "", created)
+	}
+	if profile.SystemCalls != nil {
+		col.collectSysCallsMetrics(ch, profile.SystemCalls, "", "", created)
+	}
+	if col.sme.cfg.ProfileDerived {
+		col.collectDerivedMetrics(ch, profile)
 	}
-	sysCalls := smbProfileInfo.profileStatus.SystemCalls
-	if sysCalls != nil {
-		col.collectSysCallsMetrics(ch, sysCalls, "", "")
+	col.collectUnparsableKeysMetric(ch, profile)
+	if col.sme.cfg.ProfileTopClients > 0 {
+		col.collectTopTalkersMetrics(ch, profile)
+		return
 	}
-	for key, extended := range smbProfileInfo.profileStatus.Extended {
-		sharename, client := ParseExtendedProfileKey(key)
-		if sharename == "" || client == "" {
+	for key := range profile.Extended {
+		pkey, err := ParseSMBProfileExtendedKey(key)
+		if err != nil {
 			continue
 		}
-		smb2Calls = extended.SMB2Calls
-		if smb2Calls != nil {
-			col.collectSMB2CallsMetrics(ch, smb2Calls, sharename, client)
+		ch <- prometheus.MustNewConstMetric(col.extendedInfo,
+			prometheus.GaugeValue, 1,
+			col.netbiosName, pkey.Share, pkey.Client, pkey.PID, pkey.Index)
+	}
+	// Same pair of share and client may be served by multiple connections.
+	// Their sum decreases whenever one of those (short-lived) smbd processes
+	// exits, so there is no single creation time to declare.
+	for sc, extended := range profile.AggregateExtended() {
+		if extended.SMB2Calls != nil {
+			col.collectSMB2CallsMetrics(ch, extended.SMB2Calls,
+				sc.Share, sc.Client, time.Time{})
 		}
-		sysCalls = extended.SystemCalls
-		if sysCalls != nil {
-			col.collectSysCallsMetrics(ch, sysCalls, sharename, client)
+		if extended.SystemCalls != nil {
+			col.collectSysCallsMetrics(ch, extended.SystemCalls,
+				sc.Share, sc.Client, time.Time{})
 		}
 	}
 }
 
+func (col *smbProfileCollector) collectUnparsableKeysMetric(
+	ch chan<- prometheus.Metric, profile *SMBProfile) {
+	unparsable := 0
+	for key := range profile.Extended {
+		if _, err := ParseSMBProfileExtendedKey(key); err != nil {
+			col.sme.log.V(1).Info("unparsable extended profile key",
+				"key", key)
+			unparsable++
+		}
+	}
+	ch <- prometheus.MustNewConstMetric(col.unparsableKeys,
+		prometheus.GaugeValue, float64(unparsable), col.netbiosName)
+}
+
 func (col *smbProfileCollector) collectSMB2CallsMetrics(
 	ch chan<- prometheus.Metric, smb2Calls *SMBProfileSMB2Calls,
-	sharename, client string) {
-	operationToProfileCallEntry := map[string]*SMBProfileCallEntry{
-		"negprot":   &smb2Calls.NegProt,
-		"sesssetup": &smb2Calls.SessSetup,
-		"logoff":    &smb2Calls.LogOff,
-		"tcon":      &smb2Calls.Tcon,
-		"tdis":      &smb2Calls.Tdis,
-		"create":    &smb2Calls.Create,
-		"close":     &smb2Calls.Close,
-		"flush":     &smb2Calls.Flush,
-		"read":      &smb2Calls.Read,
-		"write":     &smb2Calls.Write,
-		"lock":      &smb2Calls.Lock,
-		"ioctl":     &smb2Calls.Ioctl,
-		"cancel":    &smb2Calls.Cancel,
-		"keepalive": &smb2Calls.KeepAlive,
-		"find":      &smb2Calls.Find,
-		"notify":    &smb2Calls.Notify,
-		"getinfo":   &smb2Calls.GetInfo,
-		"setinfo":   &smb2Calls.SetInfo,
-		"break":     &smb2Calls.Break,
-	}
-	for op, pce := range operationToProfileCallEntry {
-		ch <- col.smb2RequestTotalMetric(sharename, client, op, pce)
+	sharename, client string, created time.Time) {
+	for op, pce := range smb2Calls.Operations() {
+		ch <- col.smb2RequestTotalMetric(sharename, client, op, pce, created)
 		ch <- col.smb2RequestInbytesMetric(sharename, client, op, pce)
 		ch <- col.smb2RequestOutbytesMetric(sharename, client, op, pce)
 		ch <- col.smb2RequestDurationMetric(sharename, client, op, pce)
+		hist := profileLatencyHistogram(col.smb2Latency, pce.Buckets,
+			pce.Count, pce.Time, created,
+			col.netbiosName, sharename, client, op)
+		if hist != nil {
+			ch <- hist
+		}
 	}
 }
 
 func (col *smbProfileCollector) collectSysCallsMetrics(
 	ch chan<- prometheus.Metric, sysCalls *SMBProfileSyscalls,
-	sharename, client string) {
-	operationToProfileIOEntry := map[string]*SMBProfileIOEntry{
-		"pread":       &sysCalls.PRead,
-		"asys_pread":  &sysCalls.AsysPRead,
-		"pwrite":      &sysCalls.PWrite,
-		"asys_pwrite": &sysCalls.AsysPWrite,
-		"asys_fsync":  &sysCalls.AsysFSync,
-	}
-	operationToProfileEntry := map[string]*SMBProfileEntry{
-		"opendir":    &sysCalls.Opendir,
-		"fdopendir":  &sysCalls.FDOpendir,
-		"readdir":    &sysCalls.Readdir,
-		"rewinddir":  &sysCalls.Rewinddir,
-		"mkdirat":    &sysCalls.Mkdirat,
-		"closedir":   &sysCalls.Closedir,
-		"open":       &sysCalls.Open,
-		"openat":     &sysCalls.OpenAt,
-		"createfile": &sysCalls.CreateFile,
-		"close":      &sysCalls.Close,
-		"lseek":      &sysCalls.Lseek,
-		"renameat":   &sysCalls.RenameAt,
-		"stat":       &sysCalls.Stat,
-		"fstat":      &sysCalls.FStat,
-		"lstat":      &sysCalls.LStat,
-		"fstatat":    &sysCalls.FStatAt,
-		"unlinkat":   &sysCalls.UnlinkAt,
-		"chmod":      &sysCalls.Chmod,
-		"fchmod":     &sysCalls.FChmod,
-		"fchown":     &sysCalls.FChown,
-		"lchown":     &sysCalls.LChown,
-		"chdir":      &sysCalls.Chdir,
-		"getwd":      &sysCalls.GetWD,
-		"fntimes":    &sysCalls.Fntimes,
-		"ftruncate":  &sysCalls.FTruncate,
-		"fallocate":  &sysCalls.FAllocate,
-		"readlinkat": &sysCalls.ReadLinkAt,
-		"symlinkat":  &sysCalls.SymLinkAt,
-		"linkat":     &sysCalls.LinkAt,
-		"mknodat":    &sysCalls.MknodAt,
-		"realpath":   &sysCalls.RealPath,
-	}
-	for op, pioe := range operationToProfileIOEntry {
-		ch <- col.vfsIOTotalMetric(sharename, client, op, pioe)
+	sharename, client string, created time.Time) {
+	for op, pioe := range sysCalls.IOOperations() {
+		ch <- col.vfsIOTotalMetric(sharename, client, op, pioe, created)
 		ch <- col.vfsIOBytesMetric(sharename, client, op, pioe)
 		ch <- col.vfsIODurationMetric(sharename, client, op, pioe)
+		hist := profileLatencyHistogram(col.vfsIOLatency, pioe.Buckets,
+			pioe.Count, pioe.Time, created,
+			col.netbiosName, sharename, client, op)
+		if hist != nil {
+			ch <- hist
+		}
 	}
-	for op, pe := range operationToProfileEntry {
-		ch <- col.vfsTotalMetric(sharename, client, op, pe)
+	for op, pe := range sysCalls.Operations() {
+		ch <- col.vfsTotalMetric(sharename, client, op, pe, created)
 		ch <- col.vfsDurationMetric(sharename, client, op, pe)
 	}
 }
 
-func (col *smbProfileCollector) smb2RequestTotalMetric(
-	sharename, client, operation string, pce *SMBProfileCallEntry) prometheus.Metric {
-	return prometheus.MustNewConstMetric(
+// profileTotalMetric returns a profile total as counter when so configured,
+// or otherwise as gauge (as it was exported originally)
+func (col *smbProfileCollector) profileTotalMetric(desc *prometheus.Desc, value float64,
+	created time.Time, labelValues ...string) prometheus.Metric {
+	if !col.sme.cfg.ProfileCounters {
+		return prometheus.MustNewConstMetric(desc,
+			prometheus.GaugeValue, value, labelValues...)
+	}
+	return newCounterMetric(desc, value, created, labelValues...)
+}
+
+func (col *smbProfileCollector) smb2RequestTotalMetric(sharename, client, operation string,
+	pce *SMBProfileCallEntry, created time.Time) prometheus.Metric {
+	return col.profileTotalMetric(
 		col.dsc[0],
-		prometheus.GaugeValue,
 		float64(pce.Count),
+		created,
 		col.netbiosName,
 		sharename,
 		client,
@@ -332,12 +439,12 @@ func (col *smbProfileCollector) smb2RequestDurationMetric(
 		operation)
 }
 
-func (col *smbProfileCollector) vfsIOTotalMetric(
-	sharename, client, operation string, pioe *SMBProfileIOEntry) prometheus.Metric {
-	return prometheus.MustNewConstMetric(
+func (col *smbProfileCollector) vfsIOTotalMetric(sharename, client, operation string,
+	pioe *SMBProfileIOEntry, created time.Time) prometheus.Metric {
+	return col.profileTotalMetric(
 		col.dsc[4],
-		prometheus.GaugeValue,
 		float64(pioe.Count),
+		created,
 		col.netbiosName,
 		sharename,
 		client,
@@ -368,12 +475,12 @@ func (col *smbProfileCollector) vfsIODurationMetric(
 		operation)
 }
 
-func (col *smbProfileCollector) vfsTotalMetric(
-	sharename, client, operation string, pe *SMBProfileEntry) prometheus.Metric {
-	return prometheus.MustNewConstMetric(
+func (col *smbProfileCollector) vfsTotalMetric(sharename, client, operation string,
+	pe *SMBProfileEntry, created time.Time) prometheus.Metric {
+	return col.profileTotalMetric(
 		col.dsc[7],
-		prometheus.GaugeValue,
 		float64(pe.Count),
+		created,
 		col.netbiosName,
 		sharename,
 		client,
@@ -434,7 +541,33 @@ func (sme *smbMetricsExporter) newSMBProfileCollector() prometheus.Collector {
 			"Execution time in microseconds of VFS requests",
 			variableLabels, nil),
 	}
-
+	col.clientTotals = newSMBProfileTotalsDescs("smb2_client", "client", "client")
+	col.shareTotals = newSMBProfileTotalsDescs("smb2_share", "share", "share")
+	col.extendedInfo = prometheus.NewDesc(
+		collectorName("profile", "extended_info"),
+		"Connection of per-share profile entry",
+		[]string{"netbiosname", "share", "client", "pid", "index"}, nil)
+	col.unparsableKeys = prometheus.NewDesc(
+		collectorName("profile", "extended_unparsable_keys"),
+		"Number of per-share profile entries with unparsable key",
+		[]string{"netbiosname"}, nil)
+	col.smb2Rates = newSMBProfileRateDescs("smb2_request", "SMB2 requests")
+	col.vfsIORates = newSMBProfileRateDescs("vfs_io", "VFS I/O calls")
+	col.smb2Latency = prometheus.NewDesc(
+		collectorName("smb2", "request_latency_microseconds"),
+		"Latency histogram in microseconds of SMB2 requests",
+		variableLabels, nil)
+	col.vfsIOLatency = prometheus.NewDesc(
+		collectorName("vfs_io", "latency_microseconds"),
+		"Latency histogram in microseconds of VFS I/O requests",
+		variableLabels, nil)
+
+	col.dsc = append(col.dsc, col.clientTotals...)
+	col.dsc = append(col.dsc, col.shareTotals...)
+	col.dsc = append(col.dsc, col.extendedInfo, col.unparsableKeys)
+	col.dsc = append(col.dsc, col.smb2Rates...)
+	col.dsc = append(col.dsc, col.vfsIORates...)
+	col.dsc = append(col.dsc, col.smb2Latency, col.vfsIOLatency)
 	return col
 }
 
@@ -0,0 +1,333 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package metrics
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// CTDBNodeStatus represents a single node entry of 'ctdb -X status'
+type CTDBNodeStatus struct {
+	PNN             int
+	Address         string
+	Disconnected    bool
+	Banned          bool
+	Disabled        bool
+	Unhealthy       bool
+	Stopped         bool
+	Inactive        bool
+	PartiallyOnline bool
+	ThisNode        bool
+}
+
+// CTDBPublicIP represents a single entry of 'ctdb -X ip'
+type CTDBPublicIP struct {
+	Address string
+	PNN     int
+}
+
+// CTDBStatistics represents (a subset of the) output of 'ctdb -X statistics'
+type CTDBStatistics struct {
+	NumClients         int
+	Frozen             int
+	Recovering         int
+	NumRecoveries      int
+	LockwaitLatencyMin float64
+	LockwaitLatencyAvg float64
+	LockwaitLatencyMax float64
+}
+
+// IsOK returns true if node has no error or administrative flag set
+func (ns *CTDBNodeStatus) IsOK() bool {
+	return !ns.Disconnected && !ns.Banned && !ns.Disabled &&
+		!ns.Unhealthy && !ns.Stopped && !ns.Inactive
+}
+
+// LocateCTDB finds the local executable of 'ctdb' on host.
+func LocateCTDB() (string, error) {
+	return locateExecutable("ctdb", "/usr/bin/ctdb")
+}
+
+// RunCTDBStatus executes 'ctdb -X status' on host
+func RunCTDBStatus(ctx context.Context) ([]CTDBNodeStatus, error) {
+	dat, err := executeCTDBCommand(ctx, "-X", "status")
+	if err != nil {
+		return []CTDBNodeStatus{}, err
+	}
+	return parseCTDBStatus(dat)
+}
+
+// RunCTDBStatistics executes 'ctdb -X statistics' on host
+func RunCTDBStatistics(ctx context.Context) (*CTDBStatistics, error) {
+	dat, err := executeCTDBCommand(ctx, "-X", "statistics")
+	if err != nil {
+		return &CTDBStatistics{}, err
+	}
+	return parseCTDBStatistics(dat)
+}
+
+// RunCTDBPublicIPs executes 'ctdb -X ip all' on host
+func RunCTDBPublicIPs(ctx context.Context) ([]CTDBPublicIP, error) {
+	dat, err := executeCTDBCommand(ctx, "-X", "ip", "all")
+	if err != nil {
+		return []CTDBPublicIP{}, err
+	}
+	return parseCTDBPublicIPs(dat)
+}
+
+// RunCTDBRecoveryMode executes 'ctdb getrecmode' on host and returns 0 for
+// normal mode or 1 while in recovery
+func RunCTDBRecoveryMode(ctx context.Context) (int, error) {
+	dat, err := executeCTDBCommand(ctx, "getrecmode")
+	if err != nil {
+		return 0, err
+	}
+	switch dat {
+	case "NORMAL":
+		return 0, nil
+	case "RECOVERY":
+		return 1, nil
+	}
+	return 0, fmt.Errorf("unknown ctdb recovery mode: %q", dat)
+}
+
+// RunCTDBPNN executes 'ctdb pnn' on host and returns the local node number
+func RunCTDBPNN(ctx context.Context) (string, error) {
+	dat, err := executeCTDBCommand(ctx, "pnn")
+	if err != nil {
+		return "", err
+	}
+	return parseCTDBPNN(dat)
+}
+
+func executeCTDBCommand(ctx context.Context, args ...string) (string, error) {
+	loc, err := LocateCTDB()
+	if err != nil {
+		return "", err
+	}
+	return executeCommand(ctx, loc, args...)
+}
+
+// parseCTDBMachineReadable parses the '|' separated machine-readable output
+// of ctdb tool into a list of header-to-value maps.
+func parseCTDBMachineReadable(data string) ([]map[string]string, error) {
+	rows := []map[string]string{}
+	var header []string
+	for _, line := range strings.Split(data, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+		fields := strings.Split(strings.Trim(line, "|"), "|")
+		if header == nil {
+			header = fields
+			continue
+		}
+		if len(fields) != len(header) {
+			return rows, fmt.Errorf("malformed ctdb output line: %q", line)
+		}
+		row := map[string]string{}
+		for i, name := range header {
+			row[name] = strings.TrimSpace(fields[i])
+		}
+		rows = append(rows, row)
+	}
+	if header == nil {
+		return rows, errors.New("empty ctdb output")
+	}
+	return rows, nil
+}
+
+// parseCTDBPNN parses the output of 'ctdb pnn', which is either a plain
+// number or (in older versions) of the form 'PNN:<number>'.
+func parseCTDBPNN(data string) (string, error) {
+	pnn := strings.TrimPrefix(strings.TrimSpace(data), "PNN:")
+	if _, err := strconv.ParseUint(pnn, 10, 32); err != nil {
+		return "", fmt.Errorf("malformed ctdb pnn output: %q", data)
+	}
+	return pnn, nil
+}
+
+func parseCTDBStatus(data string) ([]CTDBNodeStatus, error) {
+	nodes := []CTDBNodeStatus{}
+	rows, err := parseCTDBMachineReadable(data)
+	if err != nil {
+		return nodes, err
+	}
+	for _, row := range rows {
+		pnn, err := strconv.Atoi(row["Node"])
+		if err != nil {
+			return nodes, err
+		}
+		nodes = append(nodes, CTDBNodeStatus{
+			PNN:             pnn,
+			Address:         row["IP"],
+			Disconnected:    row["Disconnected"] == "1",
+			Banned:          row["Banned"] == "1",
+			Disabled:        row["Disabled"] == "1",
+			Unhealthy:       row["Unhealthy"] == "1",
+			Stopped:         row["Stopped"] == "1",
+			Inactive:        row["Inactive"] == "1",
+			PartiallyOnline: row["PartiallyOnline"] == "1",
+			ThisNode:        row["ThisNode"] == "Y",
+		})
+	}
+	return nodes, nil
+}
+
+func parseCTDBPublicIPs(data string) ([]CTDBPublicIP, error) {
+	ips := []CTDBPublicIP{}
+	rows, err := parseCTDBMachineReadable(data)
+	if err != nil {
+		return ips, err
+	}
+	for _, row := range rows {
+		pnn, err := strconv.Atoi(row["Node"])
+		if err != nil {
+			return ips, err
+		}
+		ips = append(ips, CTDBPublicIP{Address: row["Public IP"], PNN: pnn})
+	}
+	return ips, nil
+}
+
+func parseCTDBStatistics(data string) (*CTDBStatistics, error) {
+	stats := &CTDBStatistics{}
+	rows, err := parseCTDBMachineReadable(data)
+	if err != nil {
+		return stats, err
+	}
+	if len(rows) != 1 {
+		return stats, errors.New("malformed ctdb statistics output")
+	}
+	row := rows[0]
+	// Fields which are missing in older ctdb versions are left as zero
+	stats.NumClients, _ = strconv.Atoi(row["num_clients"])
+	stats.Frozen, _ = strconv.Atoi(row["frozen"])
+	stats.Recovering, _ = strconv.Atoi(row["recovering"])
+	stats.NumRecoveries, _ = strconv.Atoi(row["num_recoveries"])
+	stats.LockwaitLatencyMin, _ = strconv.ParseFloat(row["min_lockwait_latency"], 64)
+	stats.LockwaitLatencyAvg, _ = strconv.ParseFloat(row["avg_lockwait_latency"], 64)
+	stats.LockwaitLatencyMax, _ = strconv.ParseFloat(row["max_lockwait_latency"], 64)
+	return stats, nil
+}
+
+type smbCTDBCollector struct {
+	smbCollector
+}
+
+func (col *smbCTDBCollector) Collect(ch chan<- prometheus.Metric) {
+	if _, err := LocateCTDB(); err != nil {
+		return
+	}
+	ctx := col.sme.ctx
+	nodes, err := RunCTDBStatus(ctx)
+	if err != nil {
+		// Non-clustered setup or ctdbd not running
+		col.sme.log.V(1).Info("ctdb status failed", "err", err)
+		return
+	}
+	col.collectNodesMetrics(ch, nodes)
+
+	recmode, err := RunCTDBRecoveryMode(ctx)
+	if err == nil {
+		ch <- prometheus.MustNewConstMetric(col.dsc[2],
+			prometheus.GaugeValue, float64(recmode))
+	}
+	stats, err := RunCTDBStatistics(ctx)
+	if err == nil {
+		col.collectStatisticsMetrics(ch, stats)
+	}
+	ips, err := RunCTDBPublicIPs(ctx)
+	if err == nil {
+		for _, ip := range ips {
+			ch <- prometheus.MustNewConstMetric(col.dsc[4],
+				prometheus.GaugeValue, 1, ip.Address, strconv.Itoa(ip.PNN))
+		}
+	}
+}
+
+func (col *smbCTDBCollector) collectNodesMetrics(
+	ch chan<- prometheus.Metric, nodes []CTDBNodeStatus) {
+	for _, node := range nodes {
+		pnn := strconv.Itoa(node.PNN)
+		states := map[string]bool{
+			"ok":           node.IsOK(),
+			"disconnected": node.Disconnected,
+			"banned":       node.Banned,
+			"disabled":     node.Disabled,
+			"unhealthy":    node.Unhealthy,
+			"stopped":      node.Stopped,
+			"inactive":     node.Inactive,
+		}
+		for state, set := range states {
+			ch <- prometheus.MustNewConstMetric(col.dsc[0],
+				prometheus.GaugeValue, boolToFloat64(set),
+				pnn, node.Address, state)
+		}
+		if node.ThisNode {
+			ch <- prometheus.MustNewConstMetric(col.dsc[1],
+				prometheus.GaugeValue, float64(node.PNN))
+		}
+	}
+}
+
+func (col *smbCTDBCollector) collectStatisticsMetrics(
+	ch chan<- prometheus.Metric, stats *CTDBStatistics) {
+	ch <- prometheus.MustNewConstMetric(col.dsc[3],
+		prometheus.CounterValue, float64(stats.NumRecoveries))
+	latencies := map[string]float64{
+		"min": stats.LockwaitLatencyMin,
+		"avg": stats.LockwaitLatencyAvg,
+		"max": stats.LockwaitLatencyMax,
+	}
+	for stat, value := range latencies {
+		ch <- prometheus.MustNewConstMetric(col.dsc[5],
+			prometheus.GaugeValue, value, stat)
+	}
+}
+
+func (sme *smbMetricsExporter) newSMBCTDBCollector() prometheus.Collector {
+	col := &smbCTDBCollector{}
+	col.sme = sme
+	col.dsc = []*prometheus.Desc{
+		prometheus.NewDesc(
+			collectorName("ctdb", "node_state"),
+			"Whether CTDB node is currently in given state",
+			[]string{"pnn", "address", "state"}, nil),
+		prometheus.NewDesc(
+			collectorName("ctdb", "this_node_pnn"),
+			"PNN of the CTDB node running along-side this exporter",
+			[]string{}, nil),
+		prometheus.NewDesc(
+			collectorName("ctdb", "recovery_mode"),
+			"CTDB recovery mode (0=normal, 1=recovery)",
+			[]string{}, nil),
+		prometheus.NewDesc(
+			collectorName("ctdb", "recoveries_total"),
+			"Number of CTDB recoveries since ctdbd start",
+			[]string{}, nil),
+		prometheus.NewDesc(
+			collectorName("ctdb", "public_ip"),
+			"Assignment of CTDB public IP to node (pnn=-1 if unassigned)",
+			[]string{"address", "pnn"}, nil),
+		prometheus.NewDesc(
+			collectorName("ctdb", "lockwait_latency_seconds"),
+			"CTDB lock-wait latency in seconds",
+			[]string{"stat"}, nil),
+	}
+	return col
+}
+
+func boolToFloat64(b bool) float64 {
+	if b {
+		return 1
+	}
+	return 0
+}
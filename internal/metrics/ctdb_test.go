@@ -0,0 +1,63 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package metrics
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseCTDBStatus(t *testing.T) {
+	testdata := readTestData(t, "ctdb-status.txt")
+	nodes, err := parseCTDBStatus(testdata)
+	assert.NoError(t, err)
+	assert.Equal(t, len(nodes), 3)
+	assert.Equal(t, nodes[0].PNN, 0)
+	assert.Equal(t, nodes[0].Address, "192.168.122.91")
+	assert.True(t, nodes[0].ThisNode)
+	assert.True(t, nodes[0].IsOK())
+	assert.True(t, nodes[1].Banned)
+	assert.False(t, nodes[1].IsOK())
+	assert.False(t, nodes[1].ThisNode)
+	assert.True(t, nodes[2].Disconnected)
+	assert.False(t, nodes[2].IsOK())
+
+	_, err = parseCTDBStatus("")
+	assert.Error(t, err)
+	_, err = parseCTDBStatus("|Node|IP|\n|0|\n")
+	assert.Error(t, err)
+}
+
+func TestParseCTDBPublicIPs(t *testing.T) {
+	testdata := readTestData(t, "ctdb-ip.txt")
+	ips, err := parseCTDBPublicIPs(testdata)
+	assert.NoError(t, err)
+	assert.Equal(t, len(ips), 3)
+	assert.Equal(t, ips[0].Address, "10.0.0.11")
+	assert.Equal(t, ips[0].PNN, 0)
+	assert.Equal(t, ips[2].PNN, -1)
+}
+
+func TestParseCTDBStatistics(t *testing.T) {
+	testdata := readTestData(t, "ctdb-statistics.txt")
+	stats, err := parseCTDBStatistics(testdata)
+	assert.NoError(t, err)
+	assert.Equal(t, stats.NumClients, 4)
+	assert.Equal(t, stats.NumRecoveries, 3)
+	assert.Equal(t, stats.Recovering, 0)
+	assert.InDelta(t, stats.LockwaitLatencyMin, 0.000021, 1e-9)
+	assert.InDelta(t, stats.LockwaitLatencyAvg, 0.000350, 1e-9)
+	assert.InDelta(t, stats.LockwaitLatencyMax, 0.042107, 1e-9)
+}
+
+func TestParseCTDBPNN(t *testing.T) {
+	pnn, err := parseCTDBPNN("2\n")
+	assert.NoError(t, err)
+	assert.Equal(t, pnn, "2")
+	pnn, err = parseCTDBPNN("PNN:1")
+	assert.NoError(t, err)
+	assert.Equal(t, pnn, "1")
+	_, err = parseCTDBPNN("Failed to connect")
+	assert.Error(t, err)
+}
@@ -0,0 +1,176 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package metrics
+
+import (
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// SMBProfileRate represents per-operation values derived from two profile
+// snapshots, over the interval between them
+type SMBProfileRate struct {
+	// OpsPerSecond is the number of operations per second
+	OpsPerSecond float64
+	// BytesPerSecond is the number of bytes transferred (in both
+	// directions) per second
+	BytesPerSecond float64
+	// AvgLatency is the average execution time in microseconds of
+	// operations; zero when there were no operations
+	AvgLatency float64
+}
+
+// SMBProfileRates holds derived per-operation values of SMB2 calls and VFS
+// I/O calls
+type SMBProfileRates struct {
+	Interval time.Duration
+	SMB2     map[string]SMBProfileRate
+	VFSIO    map[string]SMBProfileRate
+}
+
+// DefaultDerivedMinInterval is the minimal interval between profile
+// snapshots from which rates are derived. Scrapes which follow the previous
+// snapshot more closely (e.g., by redundant Prometheus servers, or a one-shot
+// dump) reuse the last derived rates, rather than deriving them over a
+// too-short interval and resetting the snapshot of regular scrapes.
+var DefaultDerivedMinInterval = 5 * time.Second
+
+// smbProfileDeriver keeps the previous profile snapshot in order to derive
+// rates upon next one
+type smbProfileDeriver struct {
+	mutex     sync.Mutex
+	prev      *SMBProfile
+	prevTime  time.Time
+	prevStart time.Time
+	last      *SMBProfileRates
+}
+
+// update records profile taken at time now, from smbd started at smbdStart
+// (zero if unknown), and returns the values derived since the previous
+// snapshot, or nil if there is none. A profile taken within
+// DefaultDerivedMinInterval since the previous snapshot is discarded, and the
+// last derived values are returned instead. Upon smbd restart, the previous
+// snapshot is replaced without deriving any values, as profile counters were
+// reset at some unknown time in between.
+func (pd *smbProfileDeriver) update(profile *SMBProfile,
+	smbdStart, now time.Time) *SMBProfileRates {
+	pd.mutex.Lock()
+	defer pd.mutex.Unlock()
+	if pd.prev != nil && now.Sub(pd.prevTime) < DefaultDerivedMinInterval {
+		return pd.last
+	}
+	prev, prevTime, prevStart := pd.prev, pd.prevTime, pd.prevStart
+	pd.prev, pd.prevTime, pd.prevStart = profile, now, smbdStart
+	if prev == nil {
+		return nil
+	}
+	if !prevStart.IsZero() && !smbdStart.IsZero() && !smbdStart.Equal(prevStart) {
+		pd.last = nil
+		return nil
+	}
+	pd.last = deriveSMBProfileRates(prev, profile, now.Sub(prevTime))
+	return pd.last
+}
+
+func deriveSMBProfileRates(prev, cur *SMBProfile, interval time.Duration) *SMBProfileRates {
+	rates := &SMBProfileRates{
+		Interval: interval,
+		SMB2:     map[string]SMBProfileRate{},
+		VFSIO:    map[string]SMBProfileRate{},
+	}
+	seconds := interval.Seconds()
+	if prev.SMB2Calls != nil && cur.SMB2Calls != nil {
+		prevOps := prev.SMB2Calls.Operations()
+		for op, pce := range cur.SMB2Calls.Operations() {
+			ppce := prevOps[op]
+			count, usecs, bytes := deriveDeltas(
+				[]int{ppce.Count, ppce.Time, ppce.Inbytes + ppce.Outbytes},
+				[]int{pce.Count, pce.Time, pce.Inbytes + pce.Outbytes})
+			rates.SMB2[op] = newSMBProfileRate(count, usecs, bytes, seconds)
+		}
+	}
+	if prev.SystemCalls != nil && cur.SystemCalls != nil {
+		prevOps := prev.SystemCalls.IOOperations()
+		for op, pioe := range cur.SystemCalls.IOOperations() {
+			ppioe := prevOps[op]
+			count, usecs, bytes := deriveDeltas(
+				[]int{ppioe.Count, ppioe.Time, ppioe.Bytes},
+				[]int{pioe.Count, pioe.Time, pioe.Bytes})
+			rates.VFSIO[op] = newSMBProfileRate(count, usecs, bytes, seconds)
+		}
+	}
+	return rates
+}
+
+// deriveDeltas returns the differences of count, time (usecs) and bytes between
+// previous and current values. If any of the values decreased then smbd was
+// restarted (or its profile reset) without being detected otherwise, and the
+// current values are the deltas.
+func deriveDeltas(prev, cur []int) (count, usecs, bytes int) {
+	for i := range cur {
+		if cur[i] < prev[i] {
+			return cur[0], cur[1], cur[2]
+		}
+	}
+	return cur[0] - prev[0], cur[1] - prev[1], cur[2] - prev[2]
+}
+
+func newSMBProfileRate(count, usecs, bytes int, seconds float64) SMBProfileRate {
+	rate := SMBProfileRate{
+		OpsPerSecond:   float64(count) / seconds,
+		BytesPerSecond: float64(bytes) / seconds,
+	}
+	if count > 0 {
+		rate.AvgLatency = float64(usecs) / float64(count)
+	}
+	return rate
+}
+
+func (col *smbProfileCollector) collectDerivedMetrics(
+	ch chan<- prometheus.Metric, profile *SMBProfile) {
+	rates := col.deriver.update(profile, col.sme.smbdStartTime(), time.Now())
+	if rates == nil {
+		return
+	}
+	for op, rate := range rates.SMB2 {
+		col.collectRateMetrics(ch, col.smb2Rates, op, rate)
+	}
+	for op, rate := range rates.VFSIO {
+		col.collectRateMetrics(ch, col.vfsIORates, op, rate)
+	}
+}
+
+func (col *smbProfileCollector) collectRateMetrics(ch chan<- prometheus.Metric,
+	dsc []*prometheus.Desc, operation string, rate SMBProfileRate) {
+	values := []float64{
+		rate.OpsPerSecond,
+		rate.BytesPerSecond,
+		rate.AvgLatency,
+	}
+	for i, val := range values {
+		ch <- prometheus.MustNewConstMetric(dsc[i],
+			prometheus.GaugeValue, val, col.netbiosName, operation)
+	}
+}
+
+func newSMBProfileRateDescs(subsystem, what string) []*prometheus.Desc {
+	variableLabels := []string{"netbiosname", "operation"}
+	return []*prometheus.Desc{
+		prometheus.NewDesc(
+			collectorName(subsystem, "ops_per_second"),
+			"Rate of "+what+" per second over last scrape interval",
+			variableLabels, nil),
+		prometheus.NewDesc(
+			collectorName(subsystem, "bytes_per_second"),
+			"Throughput in bytes per second of "+what+
+				" over last scrape interval",
+			variableLabels, nil),
+		prometheus.NewDesc(
+			collectorName(subsystem, "latency_avg_microseconds"),
+			"Average execution time in microseconds of "+what+
+				" over last scrape interval",
+			variableLabels, nil),
+	}
+}
@@ -0,0 +1,87 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package metrics
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSMBProfileDeriver(t *testing.T) {
+	testdata := readTestData(t, "smbstatus-profile.json")
+	prev, err := parseSMBProfile(testdata)
+	assert.NoError(t, err)
+	cur, err := parseSMBProfile(testdata)
+	assert.NoError(t, err)
+
+	pd := smbProfileDeriver{}
+	now := time.Now()
+	assert.Nil(t, pd.update(prev, time.Time{}, now))
+
+	cur.SMB2Calls.Read.Count += 100
+	cur.SMB2Calls.Read.Time += 5000
+	cur.SMB2Calls.Read.Outbytes += 1000000
+	cur.SystemCalls.PWrite.Count += 10
+	cur.SystemCalls.PWrite.Time += 2000
+	cur.SystemCalls.PWrite.Bytes += 40960
+	rates := pd.update(cur, time.Time{}, now.Add(10*time.Second))
+	assert.NotNil(t, rates)
+	assert.Equal(t, rates.Interval, 10*time.Second)
+	read := rates.SMB2["read"]
+	assert.InDelta(t, read.OpsPerSecond, 10, 1e-9)
+	assert.InDelta(t, read.BytesPerSecond, 100000, 1e-9)
+	assert.InDelta(t, read.AvgLatency, 50, 1e-9)
+	assert.Equal(t, rates.SMB2["write"], SMBProfileRate{})
+	pwrite := rates.VFSIO["pwrite"]
+	assert.InDelta(t, pwrite.OpsPerSecond, 1, 1e-9)
+	assert.InDelta(t, pwrite.BytesPerSecond, 4096, 1e-9)
+	assert.InDelta(t, pwrite.AvgLatency, 200, 1e-9)
+
+	// Counter reset upon smbd restart
+	reset, err := parseSMBProfile(testdata)
+	assert.NoError(t, err)
+	reset.SMB2Calls.Read.Count = 20
+	reset.SMB2Calls.Read.Time = 400
+	reset.SMB2Calls.Read.Inbytes = 0
+	reset.SMB2Calls.Read.Outbytes = 2000
+	rates = pd.update(reset, time.Time{}, now.Add(20*time.Second))
+	assert.NotNil(t, rates)
+	read = rates.SMB2["read"]
+	assert.InDelta(t, read.OpsPerSecond, 2, 1e-9)
+	assert.InDelta(t, read.BytesPerSecond, 200, 1e-9)
+	assert.InDelta(t, read.AvgLatency, 20, 1e-9)
+
+	// Snapshots within minimum interval yield last rates, without
+	// replacing the previous snapshot
+	assert.Same(t, pd.update(cur, time.Time{}, now.Add(21*time.Second)), rates)
+	assert.Same(t, pd.prev, reset)
+	assert.Equal(t, pd.prevTime, now.Add(20*time.Second))
+}
+
+func TestSMBProfileDeriverRestart(t *testing.T) {
+	testdata := readTestData(t, "smbstatus-profile.json")
+	prev, err := parseSMBProfile(testdata)
+	assert.NoError(t, err)
+	cur, err := parseSMBProfile(testdata)
+	assert.NoError(t, err)
+
+	pd := smbProfileDeriver{}
+	now := time.Now()
+	started := now.Add(-time.Hour)
+	assert.Nil(t, pd.update(prev, started, now))
+
+	// Counters of restarted smbd grew beyond previous values since restart
+	cur.SMB2Calls.Read.Count += 100
+	restarted := now.Add(5 * time.Second)
+	assert.Nil(t, pd.update(cur, restarted, now.Add(10*time.Second)))
+	assert.Same(t, pd.prev, cur)
+
+	next, err := parseSMBProfile(testdata)
+	assert.NoError(t, err)
+	next.SMB2Calls.Read.Count = cur.SMB2Calls.Read.Count + 50
+	rates := pd.update(next, restarted, now.Add(20*time.Second))
+	assert.NotNil(t, rates)
+	assert.InDelta(t, rates.SMB2["read"].OpsPerSecond, 5, 1e-9)
+}
@@ -0,0 +1,167 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package metrics
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"strings"
+
+	"github.com/go-logr/logr"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/prometheus/common/expfmt"
+)
+
+const (
+	// DumpFormatText selects classic Prometheus text exposition format
+	DumpFormatText = "text"
+	// DumpFormatOpenMetrics selects OpenMetrics text exposition format
+	DumpFormatOpenMetrics = "openmetrics"
+	// DumpFormatJSON selects JSON representation of metric families
+	DumpFormatJSON = "json"
+)
+
+// DumpFormats lists the supported output formats of metrics dump
+var DumpFormats = []string{DumpFormatText, DumpFormatOpenMetrics, DumpFormatJSON}
+
+// DumpSmbMetrics runs all configured collectors once and writes the gathered
+// metrics to w in the requested format. Log followers are not started, as
+// there is nothing to follow in a one-shot run.
+func DumpSmbMetrics(ctx context.Context, log logr.Logger,
+	cfg *ExporterConfig, format string, w io.Writer) error {
+	var encode func(io.Writer, []*dto.MetricFamily) error
+	switch format {
+	case DumpFormatText:
+		encode = encodeTextMetrics
+	case DumpFormatOpenMetrics:
+		encode = func(w io.Writer, mfs []*dto.MetricFamily) error {
+			return encodeOpenMetrics(w, mfs, expfmt.NewFormat(expfmt.TypeOpenMetrics))
+		}
+	case DumpFormatJSON:
+		encode = encodeJSONMetrics
+	default:
+		return fmt.Errorf("unknown dump format: %s (expected one of: %s)",
+			format, strings.Join(DumpFormats, ", "))
+	}
+	sme := newSmbMetricsExporter(ctx, log, cfg)
+	defer sme.cancel()
+	if err := sme.register(); err != nil {
+		return err
+	}
+	mfs, err := (&unitGatherer{sme.gatherer()}).Gather()
+	if err != nil {
+		log.Error(err, "failed to gather metrics")
+		if len(mfs) == 0 {
+			return err
+		}
+	}
+	return encode(w, mfs)
+}
+
+func encodeTextMetrics(w io.Writer, mfs []*dto.MetricFamily) error {
+	enc := expfmt.NewEncoder(w, expfmt.NewFormat(expfmt.TypeTextPlain))
+	for _, mf := range mfs {
+		if err := enc.Encode(mf); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// JSONMetricFamily is the JSON representation of gathered metric family
+type JSONMetricFamily struct {
+	Name    string       `json:"name"`
+	Help    string       `json:"help,omitempty"`
+	Type    string       `json:"type"`
+	Unit    string       `json:"unit,omitempty"`
+	Metrics []JSONMetric `json:"metrics"`
+}
+
+// JSONMetric is the JSON representation of a single metric; value is set for
+// counters and gauges, while buckets or quantiles are set for histograms and
+// summaries respectively
+type JSONMetric struct {
+	Labels    map[string]string  `json:"labels,omitempty"`
+	Value     *float64           `json:"value,omitempty"`
+	Count     *uint64            `json:"count,omitempty"`
+	Sum       *float64           `json:"sum,omitempty"`
+	Buckets   map[string]uint64  `json:"buckets,omitempty"`
+	Quantiles map[string]float64 `json:"quantiles,omitempty"`
+}
+
+// MakeJSONMetricFamilies converts gathered metric families into their JSON
+// representation
+func MakeJSONMetricFamilies(mfs []*dto.MetricFamily) []JSONMetricFamily {
+	families := make([]JSONMetricFamily, 0, len(mfs))
+	for _, mf := range mfs {
+		family := JSONMetricFamily{
+			Name:    mf.GetName(),
+			Help:    mf.GetHelp(),
+			Type:    strings.ToLower(mf.GetType().String()),
+			Unit:    mf.GetUnit(),
+			Metrics: make([]JSONMetric, 0, len(mf.GetMetric())),
+		}
+		for _, m := range mf.GetMetric() {
+			family.Metrics = append(family.Metrics, makeJSONMetric(mf.GetType(), m))
+		}
+		families = append(families, family)
+	}
+	return families
+}
+
+func makeJSONMetric(mtype dto.MetricType, m *dto.Metric) JSONMetric {
+	jm := JSONMetric{}
+	if len(m.GetLabel()) > 0 {
+		jm.Labels = map[string]string{}
+		for _, lp := range m.GetLabel() {
+			jm.Labels[lp.GetName()] = lp.GetValue()
+		}
+	}
+	switch mtype {
+	case dto.MetricType_COUNTER:
+		jm.Value = jsonFloat(m.GetCounter().GetValue())
+	case dto.MetricType_GAUGE:
+		jm.Value = jsonFloat(m.GetGauge().GetValue())
+	case dto.MetricType_UNTYPED:
+		jm.Value = jsonFloat(m.GetUntyped().GetValue())
+	case dto.MetricType_SUMMARY:
+		summary := m.GetSummary()
+		count := summary.GetSampleCount()
+		jm.Count = &count
+		jm.Sum = jsonFloat(summary.GetSampleSum())
+		jm.Quantiles = map[string]float64{}
+		for _, q := range summary.GetQuantile() {
+			if value := jsonFloat(q.GetValue()); value != nil {
+				jm.Quantiles[formatFloat(q.GetQuantile())] = *value
+			}
+		}
+	case dto.MetricType_HISTOGRAM, dto.MetricType_GAUGE_HISTOGRAM:
+		hist := m.GetHistogram()
+		count := hist.GetSampleCount()
+		jm.Count = &count
+		jm.Sum = jsonFloat(hist.GetSampleSum())
+		jm.Buckets = map[string]uint64{formatFloat(math.Inf(1)): count}
+		for _, b := range hist.GetBucket() {
+			jm.Buckets[formatFloat(b.GetUpperBound())] = b.GetCumulativeCount()
+		}
+	}
+	return jm
+}
+
+// jsonFloat returns a pointer to value, or nil when value can not be
+// represented in JSON (NaN or infinity)
+func jsonFloat(value float64) *float64 {
+	if math.IsNaN(value) || math.IsInf(value, 0) {
+		return nil
+	}
+	return &value
+}
+
+func encodeJSONMetrics(w io.Writer, mfs []*dto.MetricFamily) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(MakeJSONMetricFamilies(mfs))
+}
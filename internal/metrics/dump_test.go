@@ -0,0 +1,122 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package metrics
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/go-logr/logr"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/prometheus/common/expfmt"
+	"github.com/stretchr/testify/assert"
+)
+
+func gatherTestDumpMetrics(t *testing.T) []*dto.MetricFamily {
+	sme := newTestExporter(t, &ExporterConfig{})
+	counter := prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: collectorName("test", "read_bytes_total"),
+		Help: "Test counter",
+	}, []string{"share"})
+	counter.WithLabelValues("share1").Add(4096)
+	hist := prometheus.NewHistogram(prometheus.HistogramOpts{
+		Name:    collectorName("test", "latency_microseconds"),
+		Help:    "Test histogram",
+		Buckets: []float64{10, 100},
+	})
+	hist.Observe(5)
+	hist.Observe(500)
+	sme.reg.MustRegister(counter, hist)
+	mfs, err := (&unitGatherer{sme.gatherer()}).Gather()
+	assert.NoError(t, err)
+	return mfs
+}
+
+func TestDumpFormats(t *testing.T) {
+	mfs := gatherTestDumpMetrics(t)
+
+	var buf bytes.Buffer
+	err := encodeTextMetrics(&buf, mfs)
+	assert.NoError(t, err)
+	assert.Contains(t, buf.String(), "smb_test_read_bytes_total{share=\"share1\"} 4096\n")
+	assert.NotContains(t, buf.String(), "# EOF")
+
+	buf.Reset()
+	err = encodeOpenMetrics(&buf, mfs, expfmt.NewFormat(expfmt.TypeOpenMetrics))
+	assert.NoError(t, err)
+	assert.Contains(t, buf.String(), "# UNIT smb_test_read_bytes bytes\n")
+	assert.Contains(t, buf.String(), "# EOF\n")
+
+	buf.Reset()
+	err = encodeJSONMetrics(&buf, mfs)
+	assert.NoError(t, err)
+	families := []JSONMetricFamily{}
+	err = json.Unmarshal(buf.Bytes(), &families)
+	assert.NoError(t, err)
+	assert.Len(t, families, 3)
+
+	hist := families[0]
+	assert.Equal(t, hist.Name, "smb_test_latency_microseconds")
+	assert.Equal(t, hist.Type, "histogram")
+	assert.Equal(t, hist.Unit, "microseconds")
+	assert.Len(t, hist.Metrics, 1)
+	assert.Equal(t, *hist.Metrics[0].Count, uint64(2))
+	assert.Equal(t, *hist.Metrics[0].Sum, float64(505))
+	assert.Equal(t, hist.Metrics[0].Buckets,
+		map[string]uint64{"10": 1, "100": 1, "+Inf": 2})
+
+	counter := families[1]
+	assert.Equal(t, counter.Name, "smb_test_read_bytes_total")
+	assert.Equal(t, counter.Type, "counter")
+	assert.Equal(t, counter.Unit, "bytes")
+	assert.Len(t, counter.Metrics, 1)
+	assert.Equal(t, counter.Metrics[0].Labels, map[string]string{"share": "share1"})
+	assert.Equal(t, *counter.Metrics[0].Value, float64(4096))
+
+	requests := families[2]
+	assert.Equal(t, requests.Name, "smb_test_requests_total")
+	assert.Empty(t, requests.Unit)
+}
+
+func TestDumpSmbMetrics(t *testing.T) {
+	// Only families which do not depend on Samba being available
+	cfg := &ExporterConfig{AuditLog: "testdata/audit.log"}
+	var buf bytes.Buffer
+	err := DumpSmbMetrics(t.Context(), logr.Discard(), cfg, DumpFormatText, &buf)
+	assert.NoError(t, err)
+	assert.Contains(t, buf.String(), "# TYPE smb_metrics_status gauge\n")
+	assert.Contains(t, buf.String(), "# TYPE smb_audit_parse_errors_total counter\n")
+	assert.Contains(t, buf.String(), "smb_audit_parse_errors_total 0\n")
+	assert.NotContains(t, buf.String(), "# EOF")
+
+	buf.Reset()
+	err = DumpSmbMetrics(t.Context(), logr.Discard(), cfg, DumpFormatOpenMetrics, &buf)
+	assert.NoError(t, err)
+	assert.Contains(t, buf.String(), "# TYPE smb_metrics_status gauge\n")
+	assert.Contains(t, buf.String(), "# TYPE smb_audit_parse_errors counter\n")
+	assert.Contains(t, buf.String(), "smb_audit_parse_errors_total 0.0\n")
+	assert.True(t, strings.HasSuffix(buf.String(), "# EOF\n"))
+
+	buf.Reset()
+	err = DumpSmbMetrics(t.Context(), logr.Discard(), cfg, DumpFormatJSON, &buf)
+	assert.NoError(t, err)
+	families := []JSONMetricFamily{}
+	err = json.Unmarshal(buf.Bytes(), &families)
+	assert.NoError(t, err)
+	types := map[string]string{}
+	for _, family := range families {
+		types[family.Name] = family.Type
+	}
+	assert.Equal(t, types["smb_metrics_status"], "gauge")
+	assert.Equal(t, types["smb_audit_parse_errors_total"], "counter")
+}
+
+func TestDumpUnknownFormat(t *testing.T) {
+	var buf bytes.Buffer
+	err := DumpSmbMetrics(t.Context(), logr.Discard(), &ExporterConfig{}, "xml", &buf)
+	assert.Error(t, err)
+	assert.Empty(t, buf.String())
+}
@@ -3,13 +3,17 @@
 package metrics
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"net"
 	"net/http"
+	"os"
+	"sync"
+	"time"
 
 	"github.com/go-logr/logr"
 	"github.com/prometheus/client_golang/prometheus"
-	"github.com/prometheus/client_golang/prometheus/promhttp"
 )
 
 var (
@@ -17,74 +21,285 @@ var (
 	DefaultMetricsPort = int(9922)
 	// DefaultMetricsPath is the default HTTP path to export prometheus metrics
 	DefaultMetricsPath = "/metrics"
+	// DefaultShutdownTimeout is the default grace period for in-flight
+	// requests upon shutdown
+	DefaultShutdownTimeout = 10 * time.Second
 )
 
+// ExporterConfig holds the user-supplied settings of metrics exporter
+type ExporterConfig struct {
+	// Port is the TCP port to listen on for all bind addresses
+	Port int
+	// BindAddresses is the set of IP addresses to listen on; when empty,
+	// listen on all available addresses
+	BindAddresses []net.IP
+	// UnixSocket is an optional path of Unix domain socket to listen on
+	UnixSocket string
+	// Profile enables collection of profile information
+	Profile bool
+	// ProfileTopClients enables aggregation of per-share profile information
+	// into per-client and per-share totals, limited to top talking clients
+	ProfileTopClients int
+	// ProfileDerived enables export of rates and average latencies derived
+	// from consecutive profile snapshots
+	ProfileDerived bool
+	// ProfileCounters exports the profile request and operation totals with
+	// type counter (with created timestamps) instead of type gauge
+	ProfileCounters bool
+	// LocalVNNOnly restricts smbstatus based counts to the local CTDB node
+	LocalVNNOnly bool
+	// Winbind enables (cached) health probes of winbindd via wbinfo
+	Winbind bool
+	// WinbindProbeName is the account name used for timed winbind lookup
+	// probes; when empty, use 'Domain Users' group of own domain
+	WinbindProbeName string
+	// SmbdAddress is the address used by readiness probe to check that smbd
+	// is reachable
+	SmbdAddress string
+	// ShutdownTimeout is the grace period for in-flight requests upon
+	// shutdown, after which running commands are canceled
+	ShutdownTimeout time.Duration
+	// ShareFS enables collection of capacity of file-systems which host
+	// shares
+	ShareFS bool
+	// ProcessStats enables collection of resource usage of smbd processes
+	ProcessStats bool
+	// Quota enables collection of per-user quota usage of shares
+	Quota bool
+	// QuotaMaxUsers limits the number of users per share for which quota
+	// usage is exported
+	QuotaMaxUsers int
+	// AuditLog is an optional path of Samba JSON audit log file to follow
+	AuditLog string
+	// AuditMaxRemoteAddresses limits the number of distinct remote addresses
+	// for which authentication events are exported
+	AuditMaxRemoteAddresses int
+	// SmbdLogs is the set of smbd log file paths (or glob patterns) to
+	// follow
+	SmbdLogs []string
+	// TailFromStart causes followed log files to be processed from their
+	// beginning, rather than only lines appended after start
+	TailFromStart bool
+	// OTLPEndpoint is an optional URL of OpenTelemetry collector to which
+	// metrics are periodically pushed
+	OTLPEndpoint string
+	// OTLPProtocol is the transport used for OTLP push, either 'grpc' or
+	// 'http'
+	OTLPProtocol string
+	// OTLPInterval is the interval between consecutive OTLP pushes
+	OTLPInterval time.Duration
+	// PushgatewayURL is an optional URL of Prometheus Pushgateway to which
+	// metrics are periodically pushed
+	PushgatewayURL string
+	// RemoteWriteURL is an optional URL of Prometheus remote-write endpoint
+	// to which metrics are periodically pushed
+	RemoteWriteURL string
+	// PushInterval is the interval between consecutive pushes to
+	// Pushgateway or remote-write endpoint
+	PushInterval time.Duration
+	// TextfileDir is an optional directory into which metrics are
+	// periodically written for node_exporter's textfile collector; when set,
+	// metrics are not served over HTTP
+	TextfileDir string
+	// TextfileInterval is the interval between consecutive writes of metrics
+	// into textfile directory
+	TextfileInterval time.Duration
+}
+
 type smbMetricsExporter struct {
-	log           logr.Logger
-	reg           *prometheus.Registry
-	mux           *http.ServeMux
-	port          int
-	bindAddresses []net.IP
-	profile       bool
+	// ctx is used for executing commands; it outlives the server's context
+	// until in-flight requests are done or shutdown grace period expires
+	ctx         context.Context
+	cancel      context.CancelFunc
+	log         logr.Logger
+	reg         *prometheus.Registry
+	mux         *http.ServeMux
+	cfg         ExporterConfig
+	lastCollect collectResult
+	followers   []logFollower
+	pushers     sync.WaitGroup
+	smbdStart   smbdStartCache
+	scrape      scrapeState
+	smbConf     smbConfCache
 }
 
 func newSmbMetricsExporter(
-	log logr.Logger, port int, bindAddresses []net.IP, profile bool) *smbMetricsExporter {
+	ctx context.Context, log logr.Logger, cfg *ExporterConfig) *smbMetricsExporter {
+	cmdCtx, cancel := context.WithCancel(context.WithoutCancel(ctx))
 	return &smbMetricsExporter{
-		log:           log,
-		reg:           prometheus.NewRegistry(),
-		mux:           http.NewServeMux(),
-		port:          port,
-		bindAddresses: bindAddresses,
-		profile:       profile,
+		ctx:    cmdCtx,
+		cancel: cancel,
+		log:    log,
+		reg:    prometheus.NewRegistry(),
+		mux:    http.NewServeMux(),
+		cfg:    *cfg,
 	}
 }
 
 func (sme *smbMetricsExporter) init() error {
 	sme.log.Info("register collectors")
-	return sme.register()
+	if err := sme.register(); err != nil {
+		return err
+	}
+	for _, follower := range sme.followers {
+		go follower.follow(sme.ctx)
+	}
+	return nil
+}
+
+func (sme *smbMetricsExporter) listenAddrs() []string {
+	addrs := []string{}
+	if len(sme.cfg.BindAddresses) == 0 {
+		addrs = append(addrs, fmt.Sprintf(":%d", sme.cfg.Port))
+	}
+	for _, ip := range sme.cfg.BindAddresses {
+		addrs = append(addrs,
+			net.JoinHostPort(ip.String(), fmt.Sprintf("%d", sme.cfg.Port)))
+	}
+	return addrs
+}
+
+func (sme *smbMetricsExporter) listen() ([]net.Listener, error) {
+	listeners := []net.Listener{}
+	for _, addr := range sme.listenAddrs() {
+		listener, err := net.Listen("tcp", addr)
+		if err != nil {
+			sme.log.Error(err, "failed to listen", "addr", addr)
+			closeListeners(listeners)
+			return nil, err
+		}
+		listeners = append(listeners, listener)
+	}
+	if sme.cfg.UnixSocket != "" {
+		listener, err := listenUnix(sme.cfg.UnixSocket)
+		if err != nil {
+			sme.log.Error(err, "failed to listen", "socket", sme.cfg.UnixSocket)
+			closeListeners(listeners)
+			return nil, err
+		}
+		listeners = append(listeners, listener)
+	}
+	return listeners, nil
 }
 
-func (sme *smbMetricsExporter) serve() error {
-	var addr string
-	switch len(sme.bindAddresses) {
-	case 0:
-		addr = fmt.Sprintf(":%d", sme.port)
-	case 1:
-		addr = fmt.Sprintf("%s:%d", sme.bindAddresses[0], sme.port)
+func listenUnix(path string) (net.Listener, error) {
+	// Remove stale socket left-over from previous run, but never any other
+	// kind of file which happens to be at path
+	info, err := os.Lstat(path)
+	switch {
+	case errors.Is(err, os.ErrNotExist):
+	case err != nil:
+		return nil, err
+	case info.Mode()&os.ModeSocket == 0:
+		return nil, fmt.Errorf("not a socket: %s", path)
 	default:
-		return fmt.Errorf("too many listen addresses")
+		if err := os.Remove(path); err != nil {
+			return nil, err
+		}
 	}
-	sme.log.Info("serve metrics", "addr", addr)
+	return net.Listen("unix", path)
+}
+
+func closeListeners(listeners []net.Listener) {
+	for _, listener := range listeners {
+		listener.Close()
+	}
+}
 
-	handler := promhttp.HandlerFor(sme.reg, promhttp.HandlerOpts{})
-	sme.mux.Handle(DefaultMetricsPath, handler)
+func (sme *smbMetricsExporter) serve(ctx context.Context) error {
+	sme.mux.Handle(DefaultMetricsPath, sme.metricsHandler())
+	sme.registerHealthHandlers()
+	sme.registerAPIHandlers()
+	sme.registerWebHandlers()
 
-	listener, err := net.Listen("tcp", addr)
+	listeners, err := sme.listen()
 	if err != nil {
-		sme.log.Error(err, "failed to listen", "addr", addr)
 		return err
 	}
-	defer listener.Close()
+	defer closeListeners(listeners)
 
-	if err := http.Serve(listener, sme.mux); err != nil {
-		sme.log.Error(err, "HTTP server failure", "addr", addr)
+	server := &http.Server{
+		Handler:           sme.mux,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+	errs := make(chan error, len(listeners))
+	for _, listener := range listeners {
+		sme.log.Info("serve metrics", "addr", listener.Addr().String())
+		go func(listener net.Listener) {
+			err := server.Serve(listener)
+			if err != nil && !errors.Is(err, http.ErrServerClosed) {
+				sme.log.Error(err, "HTTP server failure",
+					"addr", listener.Addr().String())
+				errs <- err
+			}
+		}(listener)
+	}
+
+	select {
+	case err = <-errs:
+		server.Close()
+		return err
+	case <-ctx.Done():
+	}
+	return sme.shutdown(server)
+}
+
+func (sme *smbMetricsExporter) shutdown(server *http.Server) error {
+	sme.log.Info("shutdown", "timeout", sme.cfg.ShutdownTimeout)
+	ctx, cancel := context.WithTimeout(context.Background(), sme.cfg.ShutdownTimeout)
+	defer cancel()
+
+	err := server.Shutdown(ctx)
+	// Let push-mode exporters complete their final push before commands are
+	// terminated
+	sme.waitPushers(ctx)
+	// Terminate commands which are still running after grace period, so that
+	// remaining in-flight requests may complete
+	sme.cancel()
+	if err != nil {
+		sme.log.Error(err, "HTTP server shutdown failure")
+		server.Close()
 		return err
 	}
 	return nil
 }
 
 // RunSmbMetricsExporter executes an HTTP server and exports SMB metrics to
-// Prometheus.
+// Prometheus, or writes them periodically into textfile directory. It
+// returns after the server has been shut down upon cancellation of ctx.
 func RunSmbMetricsExporter(
-	log logr.Logger, port int, bindAddresses []net.IP, profile bool) error {
-	if port <= 0 {
-		port = DefaultMetricsPort
+	ctx context.Context, log logr.Logger, cfg *ExporterConfig) error {
+	if cfg.Port <= 0 {
+		cfg.Port = DefaultMetricsPort
 	}
-	sme := newSmbMetricsExporter(log, port, bindAddresses, profile)
+	if cfg.SmbdAddress == "" {
+		cfg.SmbdAddress = DefaultSmbdAddress
+	}
+	if cfg.ShutdownTimeout <= 0 {
+		cfg.ShutdownTimeout = DefaultShutdownTimeout
+	}
+	if cfg.OTLPInterval <= 0 {
+		cfg.OTLPInterval = DefaultOTLPInterval
+	}
+	if cfg.PushInterval <= 0 {
+		cfg.PushInterval = DefaultPushInterval
+	}
+	if cfg.TextfileInterval <= 0 {
+		cfg.TextfileInterval = DefaultTextfileInterval
+	}
+	if err := ValidateOTLPProtocol(cfg.OTLPProtocol); err != nil {
+		log.Error(err, "illegal OTLP configuration")
+		return err
+	}
+	sme := newSmbMetricsExporter(ctx, log, cfg)
+	defer sme.cancel()
 	err := sme.init()
 	if err != nil {
 		return err
 	}
-	return sme.serve()
+	sme.startPushers(ctx)
+	if cfg.TextfileDir != "" {
+		return sme.runTextfile(ctx)
+	}
+	return sme.serve(ctx)
 }
@@ -0,0 +1,108 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package metrics
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/go-logr/logr"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/assert"
+)
+
+// newTestExporter returns an exporter of pod 'smb-0' in namespace 'samba',
+// whose registry holds the counter smb_test_requests_total{share="share1"}
+// with value 3
+func newTestExporter(t *testing.T, cfg *ExporterConfig) *smbMetricsExporter {
+	t.Setenv(PodNameEnvKey, "smb-0")
+	t.Setenv(PodNamespaceEnvKey, "samba")
+	cfg.ShutdownTimeout = 10 * time.Second
+	sme := newSmbMetricsExporter(t.Context(), logr.Discard(), cfg)
+	counter := prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: collectorName("test", "requests_total"),
+		Help: "Test counter",
+	}, []string{"share"})
+	counter.WithLabelValues("share1").Add(3)
+	sme.reg.MustRegister(counter)
+	return sme
+}
+
+func TestListenUnixNotSocket(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "smbmetrics.sock")
+	err := os.WriteFile(path, []byte("data"), 0o600)
+	assert.NoError(t, err)
+	_, err = listenUnix(path)
+	assert.Error(t, err)
+	dat, err := os.ReadFile(path)
+	assert.NoError(t, err)
+	assert.Equal(t, string(dat), "data")
+}
+
+func TestListenUnixStaleSocket(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "smbmetrics.sock")
+	stale, err := net.Listen("unix", path)
+	assert.NoError(t, err)
+	// Leave socket file behind, as a crashed process would
+	stale.(*net.UnixListener).SetUnlinkOnClose(false)
+	stale.Close()
+
+	listener, err := listenUnix(path)
+	assert.NoError(t, err)
+	listener.Close()
+}
+
+func TestServeUnixSocket(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "smbmetrics.sock")
+	sme := newSmbMetricsExporter(t.Context(), logr.Discard(), &ExporterConfig{
+		BindAddresses:   []net.IP{net.IPv4(127, 0, 0, 1)},
+		UnixSocket:      path,
+		ShutdownTimeout: 5 * time.Second,
+	})
+	counter := prometheus.NewCounter(prometheus.CounterOpts{
+		Name: collectorName("test", "requests_total"),
+		Help: "Test counter",
+	})
+	counter.Add(3)
+	sme.reg.MustRegister(counter)
+
+	ctx, cancel := context.WithCancel(t.Context())
+	done := make(chan error, 1)
+	go func() {
+		done <- sme.serve(ctx)
+	}()
+
+	client := &http.Client{Transport: &http.Transport{
+		DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+			return (&net.Dialer{}).DialContext(ctx, "unix", path)
+		},
+	}}
+	var body []byte
+	assert.Eventually(t, func() bool {
+		resp, err := client.Get("http://localhost" + DefaultMetricsPath)
+		if err != nil {
+			return false
+		}
+		defer resp.Body.Close()
+		body, err = io.ReadAll(resp.Body)
+		return err == nil && resp.StatusCode == http.StatusOK
+	}, 5*time.Second, 10*time.Millisecond)
+	assert.Contains(t, string(body), "smb_test_requests_total 3\n")
+
+	cancel()
+	select {
+	case err := <-done:
+		assert.NoError(t, err)
+	case <-time.After(10 * time.Second):
+		assert.Fail(t, "graceful shutdown timed out")
+	}
+	assert.Error(t, sme.ctx.Err())
+	_, err := os.Lstat(path)
+	assert.ErrorIs(t, err, os.ErrNotExist)
+}
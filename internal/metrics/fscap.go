@@ -0,0 +1,181 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package metrics
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// SMBShareFSStat represents the capacity of the file-system which hosts a
+// share's root directory
+type SMBShareFSStat struct {
+	SizeBytes  uint64
+	FreeBytes  uint64
+	AvailBytes uint64
+	Files      uint64
+	FilesFree  uint64
+}
+
+// DefaultShareFSStatTimeout is the time limit of inspecting the file-system
+// of a single share, so that a hung (e.g., network) file-system does not
+// block the entire scrape
+var DefaultShareFSStatTimeout = 2 * time.Second
+
+// StatShareFS returns the file-system capacity of a share's root directory
+func StatShareFS(share *SMBShareConfig) (*SMBShareFSStat, error) {
+	path, err := shareFSPath(share)
+	if err != nil {
+		return nil, err
+	}
+	return statFS(path)
+}
+
+// shareFSPath returns the share's root directory, if it can be inspected
+func shareFSPath(share *SMBShareConfig) (string, error) {
+	path := share.Path()
+	if path == "" {
+		return "", errors.New("share has no path")
+	}
+	// Paths with variable substitutions (e.g. '%U') are resolved per
+	// connection and can not be inspected up-front
+	if strings.Contains(path, "%") {
+		return "", errors.New("share path has variable substitutions")
+	}
+	return path, nil
+}
+
+type shareFSStatResult struct {
+	stat *SMBShareFSStat
+	err  error
+}
+
+type smbShareFSCollector struct {
+	smbCollector
+	stat    func(share *SMBShareConfig) (*SMBShareFSStat, error)
+	timeout time.Duration
+	mutex   sync.Mutex
+	// pending tracks paths whose statfs has not returned yet (possibly
+	// hung forever), so that they are not stat'ed again and again
+	pending map[string]bool
+}
+
+func (col *smbShareFSCollector) Collect(ch chan<- prometheus.Metric) {
+	shares, err := col.sme.smbConfShares()
+	if err != nil {
+		col.sme.log.V(1).Info("failed to resolve shares configuration",
+			"err", err)
+		return
+	}
+	for i := range shares {
+		share := &shares[i]
+		if _, err := shareFSPath(share); err != nil {
+			col.sme.log.V(1).Info("skipped share file-system",
+				"share", share.Name, "err", err)
+			continue
+		}
+		stat, err := col.statWithTimeout(share)
+		ch <- prometheus.MustNewConstMetric(col.dsc[5],
+			prometheus.GaugeValue, boolToFloat64(err == nil),
+			share.Name, share.Path())
+		if err != nil {
+			col.sme.log.V(1).Info("failed to stat share file-system",
+				"share", share.Name, "err", err)
+			continue
+		}
+		col.collectShareFS(ch, share, stat)
+	}
+}
+
+// statWithTimeout inspects the share's file-system in background, and gives
+// up waiting for it once timeout expires
+func (col *smbShareFSCollector) statWithTimeout(
+	share *SMBShareConfig) (*SMBShareFSStat, error) {
+	path := share.Path()
+	col.mutex.Lock()
+	if col.pending[path] {
+		col.mutex.Unlock()
+		return nil, fmt.Errorf("previous statfs of %s still pending", path)
+	}
+	col.pending[path] = true
+	col.mutex.Unlock()
+
+	res := make(chan shareFSStatResult, 1)
+	statFunc := col.stat
+	go func() {
+		stat, err := statFunc(share)
+		col.mutex.Lock()
+		delete(col.pending, path)
+		col.mutex.Unlock()
+		res <- shareFSStatResult{stat: stat, err: err}
+	}()
+	timer := time.NewTimer(col.timeout)
+	defer timer.Stop()
+	select {
+	case r := <-res:
+		return r.stat, r.err
+	case <-timer.C:
+		return nil, fmt.Errorf("statfs of %s timed out after %s",
+			path, col.timeout)
+	case <-col.sme.ctx.Done():
+		return nil, col.sme.ctx.Err()
+	}
+}
+
+func (col *smbShareFSCollector) collectShareFS(ch chan<- prometheus.Metric,
+	share *SMBShareConfig, stat *SMBShareFSStat) {
+	values := []uint64{
+		stat.SizeBytes,
+		stat.FreeBytes,
+		stat.AvailBytes,
+		stat.Files,
+		stat.FilesFree,
+	}
+	for i, val := range values {
+		ch <- prometheus.MustNewConstMetric(col.dsc[i],
+			prometheus.GaugeValue, float64(val),
+			share.Name, share.Path())
+	}
+}
+
+func (sme *smbMetricsExporter) newSMBShareFSCollector() prometheus.Collector {
+	labels := []string{"service", "path"}
+	col := &smbShareFSCollector{
+		stat:    StatShareFS,
+		timeout: DefaultShareFSStatTimeout,
+		pending: map[string]bool{},
+	}
+	col.sme = sme
+	col.dsc = []*prometheus.Desc{
+		prometheus.NewDesc(
+			collectorName("share", "fs_size_bytes"),
+			"Total size in bytes of file-system hosting share",
+			labels, nil),
+		prometheus.NewDesc(
+			collectorName("share", "fs_free_bytes"),
+			"Free bytes of file-system hosting share",
+			labels, nil),
+		prometheus.NewDesc(
+			collectorName("share", "fs_avail_bytes"),
+			"Bytes available to unprivileged users on file-system hosting share",
+			labels, nil),
+		prometheus.NewDesc(
+			collectorName("share", "fs_files"),
+			"Total number of inodes of file-system hosting share",
+			labels, nil),
+		prometheus.NewDesc(
+			collectorName("share", "fs_files_free"),
+			"Free inodes of file-system hosting share",
+			labels, nil),
+		prometheus.NewDesc(
+			collectorName("share", "fs_stat_ok"),
+			"Whether file-system hosting share was inspected in time",
+			labels, nil),
+	}
+	return col
+}
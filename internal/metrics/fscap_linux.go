@@ -0,0 +1,24 @@
+// SPDX-License-Identifier: Apache-2.0
+
+//go:build linux
+
+package metrics
+
+import (
+	"syscall"
+)
+
+func statFS(path string) (*SMBShareFSStat, error) {
+	st := syscall.Statfs_t{}
+	if err := syscall.Statfs(path, &st); err != nil {
+		return nil, err
+	}
+	bsize := uint64(st.Bsize)
+	return &SMBShareFSStat{
+		SizeBytes:  st.Blocks * bsize,
+		FreeBytes:  st.Bfree * bsize,
+		AvailBytes: st.Bavail * bsize,
+		Files:      st.Files,
+		FilesFree:  st.Ffree,
+	}, nil
+}
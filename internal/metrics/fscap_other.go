@@ -0,0 +1,13 @@
+// SPDX-License-Identifier: Apache-2.0
+
+//go:build !linux
+
+package metrics
+
+import (
+	"errors"
+)
+
+func statFS(_ string) (*SMBShareFSStat, error) {
+	return nil, errors.New("statfs not supported on this platform")
+}
@@ -0,0 +1,72 @@
+// SPDX-License-Identifier: Apache-2.0
+
+//go:build linux
+
+package metrics
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/go-logr/logr"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStatShareFS(t *testing.T) {
+	dir := t.TempDir()
+	share := &SMBShareConfig{
+		Name:   "share",
+		Params: map[string]string{"path": dir},
+	}
+	stat, err := StatShareFS(share)
+	assert.NoError(t, err)
+	assert.Greater(t, stat.SizeBytes, uint64(0))
+	assert.GreaterOrEqual(t, stat.SizeBytes, stat.FreeBytes)
+	assert.GreaterOrEqual(t, stat.FreeBytes, stat.AvailBytes)
+	assert.GreaterOrEqual(t, stat.Files, stat.FilesFree)
+
+	share.Params["path"] = filepath.Join(dir, "missing")
+	_, err = StatShareFS(share)
+	assert.Error(t, err)
+
+	share.Params["path"] = "/home/%U"
+	_, err = StatShareFS(share)
+	assert.Error(t, err)
+
+	share.Params["path"] = ""
+	_, err = StatShareFS(share)
+	assert.Error(t, err)
+}
+
+func TestShareFSCollectorTimeout(t *testing.T) {
+	sme := newSmbMetricsExporter(t.Context(), logr.Discard(), &ExporterConfig{})
+	col := sme.newSMBShareFSCollector().(*smbShareFSCollector)
+	release := make(chan struct{})
+	defer close(release)
+	col.stat = func(_ *SMBShareConfig) (*SMBShareFSStat, error) {
+		<-release
+		return &SMBShareFSStat{}, nil
+	}
+	col.timeout = 10 * time.Millisecond
+	share := &SMBShareConfig{
+		Name:   "share",
+		Params: map[string]string{"path": "/mnt/hung"},
+	}
+	_, err := col.statWithTimeout(share)
+	assert.Error(t, err)
+	// Hung statfs is not repeated
+	start := time.Now()
+	_, err = col.statWithTimeout(share)
+	assert.Error(t, err)
+	assert.Less(t, time.Since(start), col.timeout)
+
+	col.stat = StatShareFS
+	share = &SMBShareConfig{
+		Name:   "share",
+		Params: map[string]string{"path": t.TempDir()},
+	}
+	stat, err := col.statWithTimeout(share)
+	assert.NoError(t, err)
+	assert.Greater(t, stat.SizeBytes, uint64(0))
+}
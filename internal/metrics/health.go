@@ -0,0 +1,118 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package metrics
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	// DefaultHealthzPath is the default HTTP path of liveness probe
+	DefaultHealthzPath = "/healthz"
+	// DefaultReadyzPath is the default HTTP path of readiness probe
+	DefaultReadyzPath = "/readyz"
+	// DefaultSmbdAddress is the default address used to check smbd is
+	// reachable
+	DefaultSmbdAddress = "localhost:445"
+	// readinessCheckTimeout bounds the execution time of each readiness
+	// check
+	readinessCheckTimeout = 5 * time.Second
+)
+
+// collectResult keeps track of the most recent smbstatus collection
+type collectResult struct {
+	mu   sync.Mutex
+	err  error
+	done bool
+}
+
+func (cr *collectResult) update(err error) {
+	cr.mu.Lock()
+	defer cr.mu.Unlock()
+	cr.err = err
+	cr.done = true
+}
+
+func (cr *collectResult) check(_ context.Context) error {
+	cr.mu.Lock()
+	defer cr.mu.Unlock()
+	if !cr.done {
+		// No scrape yet; do not block readiness on the first one
+		return nil
+	}
+	return cr.err
+}
+
+type readinessCheck struct {
+	name  string
+	check func(ctx context.Context) error
+}
+
+func (sme *smbMetricsExporter) readinessChecks() []readinessCheck {
+	return []readinessCheck{
+		{name: "smbstatus", check: checkSMBStatus},
+		{name: "collection", check: sme.lastCollect.check},
+		{name: "smbd", check: sme.checkSmbdReachable},
+	}
+}
+
+func checkSMBStatus(ctx context.Context) error {
+	if _, err := LocateSMBStatus(); err != nil {
+		return err
+	}
+	_, err := RunSMBStatusVersion(ctx)
+	return err
+}
+
+func (sme *smbMetricsExporter) checkSmbdReachable(ctx context.Context) error {
+	dialer := net.Dialer{}
+	conn, err := dialer.DialContext(ctx, "tcp", sme.cfg.SmbdAddress)
+	if err != nil {
+		return err
+	}
+	return conn.Close()
+}
+
+func (sme *smbMetricsExporter) registerHealthHandlers() {
+	sme.mux.HandleFunc(DefaultHealthzPath, serveHealthz)
+	sme.mux.HandleFunc(DefaultReadyzPath, func(w http.ResponseWriter, r *http.Request) {
+		serveChecks(w, r, sme.readinessChecks())
+	})
+}
+
+func serveHealthz(w http.ResponseWriter, _ *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	fmt.Fprintln(w, "ok")
+}
+
+func serveChecks(w http.ResponseWriter, r *http.Request, checks []readinessCheck) {
+	var sb strings.Builder
+	var errs []error
+	for _, rc := range checks {
+		ctx, cancel := context.WithTimeout(r.Context(), readinessCheckTimeout)
+		err := rc.check(ctx)
+		cancel()
+		if err != nil {
+			fmt.Fprintf(&sb, "[-]%s failed: %v\n", rc.name, err)
+			errs = append(errs, err)
+		} else {
+			fmt.Fprintf(&sb, "[+]%s ok\n", rc.name)
+		}
+	}
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	if err := errors.Join(errs...); err != nil {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		fmt.Fprint(w, sb.String())
+		fmt.Fprintln(w, "readyz check failed")
+		return
+	}
+	fmt.Fprint(w, sb.String())
+	fmt.Fprintln(w, "readyz check passed")
+}
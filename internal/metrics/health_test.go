@@ -0,0 +1,46 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package metrics
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestServeChecks(t *testing.T) {
+	okCheck := func(_ context.Context) error { return nil }
+	badCheck := func(_ context.Context) error { return errors.New("no smbd") }
+
+	req := httptest.NewRequest(http.MethodGet, DefaultReadyzPath, nil)
+	rec := httptest.NewRecorder()
+	serveChecks(rec, req, []readinessCheck{
+		{name: "a", check: okCheck},
+		{name: "b", check: okCheck},
+	})
+	assert.Equal(t, rec.Code, http.StatusOK)
+	assert.Contains(t, rec.Body.String(), "[+]a ok")
+	assert.Contains(t, rec.Body.String(), "readyz check passed")
+
+	rec = httptest.NewRecorder()
+	serveChecks(rec, req, []readinessCheck{
+		{name: "a", check: okCheck},
+		{name: "b", check: badCheck},
+	})
+	assert.Equal(t, rec.Code, http.StatusServiceUnavailable)
+	assert.Contains(t, rec.Body.String(), "[-]b failed: no smbd")
+	assert.Contains(t, rec.Body.String(), "readyz check failed")
+}
+
+func TestCollectResult(t *testing.T) {
+	cr := collectResult{}
+	assert.NoError(t, cr.check(context.TODO()))
+	cr.update(errors.New("smbstatus failed"))
+	assert.Error(t, cr.check(context.TODO()))
+	cr.update(nil)
+	assert.NoError(t, cr.check(context.TODO()))
+}
@@ -0,0 +1,174 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package metrics
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/go-logr/logr"
+	"github.com/klauspost/compress/gzhttp"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/prometheus/common/expfmt"
+)
+
+var (
+	// metricUnits lists the units which are declared in OpenMetrics
+	// exposition for metrics whose name ends with them
+	metricUnits = []string{"bytes", "seconds", "microseconds"}
+)
+
+// unitGatherer declares the unit of gathered metric families, based on
+// their name suffix
+type unitGatherer struct {
+	prometheus.Gatherer
+}
+
+func (ug *unitGatherer) Gather() ([]*dto.MetricFamily, error) {
+	mfs, err := ug.Gatherer.Gather()
+	for _, mf := range mfs {
+		if unit := metricFamilyUnit(mf); unit != "" {
+			mf.Unit = &unit
+		}
+	}
+	return mfs, err
+}
+
+func metricFamilyUnit(mf *dto.MetricFamily) string {
+	name := mf.GetName()
+	if mf.GetType() == dto.MetricType_COUNTER {
+		name = strings.TrimSuffix(name, "_total")
+	}
+	for _, unit := range metricUnits {
+		if strings.HasSuffix(name, "_"+unit) {
+			return unit
+		}
+	}
+	return ""
+}
+
+// metricsHandler serves metrics via promhttp, in OpenMetrics format
+// (including created timestamps) to clients which accept it, and in classic
+// Prometheus text format to all others. promhttp does not declare units of
+// metrics, so these are inserted into its (uncompressed) OpenMetrics output,
+// which is then compressed as negotiated by gzhttp.
+func (sme *smbMetricsExporter) metricsHandler() http.Handler {
+	handler := promhttp.HandlerFor(&unitGatherer{sme.gatherer()}, promhttp.HandlerOpts{
+		ErrorLog:                            &promhttpErrorLog{sme.log},
+		EnableOpenMetrics:                   true,
+		EnableOpenMetricsTextCreatedSamples: true,
+	})
+	return gzhttp.GzipHandler(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			r = r.Clone(r.Context())
+			r.Header.Del("Accept-Encoding")
+			uw := &unitWriter{ResponseWriter: w}
+			handler.ServeHTTP(uw, r)
+			uw.flush()
+		}))
+}
+
+// promhttpErrorLog adapts logger to promhttp's error log
+type promhttpErrorLog struct {
+	log logr.Logger
+}
+
+func (pl *promhttpErrorLog) Println(v ...any) {
+	pl.log.Error(errors.New(fmt.Sprint(v...)), "failed to serve metrics")
+}
+
+// unitWriter inserts a UNIT metadata line after the TYPE line of each
+// metric family of OpenMetrics output whose name ends with a known unit.
+// Any other output passes through unmodified.
+type unitWriter struct {
+	http.ResponseWriter
+	checked     bool
+	openMetrics bool
+	partial     []byte
+}
+
+func (uw *unitWriter) Write(p []byte) (int, error) {
+	if !uw.checked {
+		uw.checked = true
+		format := expfmt.Format(uw.Header().Get("Content-Type"))
+		uw.openMetrics = format.FormatType() == expfmt.TypeOpenMetrics
+	}
+	if !uw.openMetrics {
+		return uw.ResponseWriter.Write(p)
+	}
+	uw.partial = append(uw.partial, p...)
+	var out bytes.Buffer
+	for {
+		idx := bytes.IndexByte(uw.partial, '\n')
+		if idx < 0 {
+			break
+		}
+		line := uw.partial[:idx+1]
+		out.Write(line)
+		out.WriteString(unitLineOf(string(line)))
+		uw.partial = uw.partial[idx+1:]
+	}
+	if _, err := uw.ResponseWriter.Write(out.Bytes()); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+func (uw *unitWriter) flush() {
+	if len(uw.partial) > 0 {
+		_, _ = uw.ResponseWriter.Write(uw.partial)
+		uw.partial = nil
+	}
+}
+
+// unitLineOf returns the UNIT line which follows typeLine, or empty string
+// if it is not a TYPE line or the metric family has no known unit
+func unitLineOf(typeLine string) string {
+	fields := strings.Fields(typeLine)
+	if len(fields) != 4 || fields[0] != "#" || fields[1] != "TYPE" {
+		return ""
+	}
+	// The TYPE line of counters names the family without '_total' suffix
+	mf := &dto.MetricFamily{Name: &fields[2]}
+	unit := metricFamilyUnit(mf)
+	if unit == "" {
+		return ""
+	}
+	return "# UNIT " + fields[2] + " " + unit + "\n"
+}
+
+// encodeOpenMetrics writes metric families in OpenMetrics format, including
+// unit metadata and created timestamps
+func encodeOpenMetrics(
+	w io.Writer, mfs []*dto.MetricFamily, format expfmt.Format) error {
+	enc := expfmt.NewEncoder(w, format,
+		expfmt.WithUnit(), expfmt.WithCreatedLines())
+	for _, mf := range mfs {
+		if err := enc.Encode(mf); err != nil {
+			return err
+		}
+	}
+	if closer, ok := enc.(expfmt.Closer); ok {
+		return closer.Close()
+	}
+	return nil
+}
+
+// newCounterMetric returns a constant counter metric, with created
+// timestamp unless it is unknown (zero)
+func newCounterMetric(desc *prometheus.Desc, value float64,
+	created time.Time, labelValues ...string) prometheus.Metric {
+	if created.IsZero() {
+		return prometheus.MustNewConstMetric(desc,
+			prometheus.CounterValue, value, labelValues...)
+	}
+	return prometheus.MustNewConstMetricWithCreatedTimestamp(desc,
+		prometheus.CounterValue, value, created, labelValues...)
+}
@@ -0,0 +1,101 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package metrics
+
+import (
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/go-logr/logr"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMetricsHandlerOpenMetrics(t *testing.T) {
+	sme := newSmbMetricsExporter(t.Context(), logr.Discard(), &ExporterConfig{})
+	counter := prometheus.NewCounter(prometheus.CounterOpts{
+		Name: collectorName("test", "read_bytes_total"),
+		Help: "Test counter",
+	})
+	counter.Add(4096)
+	gauge := prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: collectorName("test", "latency_microseconds"),
+		Help: "Test gauge",
+	})
+	sme.reg.MustRegister(counter, gauge)
+	handler := sme.metricsHandler()
+
+	req := httptest.NewRequest(http.MethodGet, DefaultMetricsPath, nil)
+	req.Header.Set("Accept", "application/openmetrics-text;version=1.0.0")
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	assert.Equal(t, rec.Code, http.StatusOK)
+	assert.Contains(t, rec.Header().Get("Content-Type"), "application/openmetrics-text")
+	body, err := io.ReadAll(rec.Body)
+	assert.NoError(t, err)
+	assert.Contains(t, string(body), "# UNIT smb_test_read_bytes bytes\n")
+	assert.Contains(t, string(body), "smb_test_read_bytes_total 4096.0\n")
+	assert.Contains(t, string(body), "smb_test_read_bytes_created ")
+	assert.Contains(t, string(body), "# UNIT smb_test_latency_microseconds microseconds\n")
+	assert.Contains(t, string(body), "# EOF\n")
+
+	req = httptest.NewRequest(http.MethodGet, DefaultMetricsPath, nil)
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	assert.Equal(t, rec.Code, http.StatusOK)
+	assert.Contains(t, rec.Header().Get("Content-Type"), "text/plain")
+	body, err = io.ReadAll(rec.Body)
+	assert.NoError(t, err)
+	assert.Contains(t, string(body), "smb_test_read_bytes_total 4096\n")
+	assert.NotContains(t, string(body), "# UNIT")
+}
+
+func TestMetricsHandlerOpenMetricsGzip(t *testing.T) {
+	sme := newSmbMetricsExporter(t.Context(), logr.Discard(), &ExporterConfig{})
+	gauge := prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: collectorName("test", "size_bytes"),
+		Help: "Test gauge",
+	}, []string{"share"})
+	for i := range 100 {
+		gauge.WithLabelValues(fmt.Sprintf("share%d", i)).Set(float64(i))
+	}
+	sme.reg.MustRegister(gauge)
+	req := httptest.NewRequest(http.MethodGet, DefaultMetricsPath, nil)
+	req.Header.Set("Accept", "application/openmetrics-text;version=1.0.0")
+	req.Header.Set("Accept-Encoding", "gzip")
+	rec := httptest.NewRecorder()
+	sme.metricsHandler().ServeHTTP(rec, req)
+	assert.Equal(t, rec.Code, http.StatusOK)
+	assert.Equal(t, rec.Header().Get("Content-Encoding"), "gzip")
+	zr, err := gzip.NewReader(rec.Body)
+	assert.NoError(t, err)
+	body, err := io.ReadAll(zr)
+	assert.NoError(t, err)
+	assert.Contains(t, string(body), "# UNIT smb_test_size_bytes bytes\n")
+	assert.Contains(t, string(body), "smb_test_size_bytes{share=\"share99\"} 99.0\n")
+}
+
+func TestMetricsHandlerOpenMetricsGatherError(t *testing.T) {
+	sme := newSmbMetricsExporter(t.Context(), logr.Discard(), &ExporterConfig{})
+	sme.reg.MustRegister(failingCollector{})
+	req := httptest.NewRequest(http.MethodGet, DefaultMetricsPath, nil)
+	req.Header.Set("Accept", "application/openmetrics-text;version=1.0.0")
+	rec := httptest.NewRecorder()
+	sme.metricsHandler().ServeHTTP(rec, req)
+	assert.Equal(t, rec.Code, http.StatusInternalServerError)
+}
+
+// failingCollector is an unchecked collector whose collection always fails
+type failingCollector struct{}
+
+func (failingCollector) Describe(chan<- *prometheus.Desc) {}
+
+func (failingCollector) Collect(ch chan<- prometheus.Metric) {
+	desc := prometheus.NewDesc(collectorName("test", "value"), "Test", nil, nil)
+	ch <- prometheus.NewInvalidMetric(desc, errors.New("test failure"))
+}
@@ -0,0 +1,134 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package metrics
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	prometheusbridge "go.opentelemetry.io/contrib/bridges/prometheus"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/exporters/otlp/otlpmetric/otlpmetricgrpc"
+	"go.opentelemetry.io/otel/exporters/otlp/otlpmetric/otlpmetrichttp"
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
+	"go.opentelemetry.io/otel/sdk/resource"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+)
+
+const (
+	// OTLPProtocolGRPC selects OTLP over gRPC
+	OTLPProtocolGRPC = "grpc"
+	// OTLPProtocolHTTP selects OTLP over HTTP with protobuf payload
+	OTLPProtocolHTTP = "http"
+)
+
+var (
+	// DefaultOTLPInterval is the default interval between consecutive
+	// pushes of metrics to OTLP endpoint
+	DefaultOTLPInterval = 30 * time.Second
+)
+
+// ValidateOTLPProtocol returns error if protocol is neither empty (which
+// implies gRPC) nor one of the supported OTLP transport protocols
+func ValidateOTLPProtocol(protocol string) error {
+	switch protocol {
+	case "", OTLPProtocolGRPC, OTLPProtocolHTTP:
+		return nil
+	}
+	return fmt.Errorf("unknown OTLP protocol: %s (expected one of: %s, %s)",
+		protocol, OTLPProtocolGRPC, OTLPProtocolHTTP)
+}
+
+func (sme *smbMetricsExporter) newOTLPExporter(
+	ctx context.Context) (sdkmetric.Exporter, error) {
+	// Endpoint is an URL; plain 'http' scheme implies insecure transport.
+	// Other settings (headers, TLS certificates, compression) are taken from
+	// the standard OTEL_EXPORTER_OTLP_* environment variables.
+	endpoint := sme.cfg.OTLPEndpoint
+	if err := ValidateOTLPProtocol(sme.cfg.OTLPProtocol); err != nil {
+		return nil, err
+	}
+	if sme.cfg.OTLPProtocol == OTLPProtocolHTTP {
+		return otlpmetrichttp.New(ctx, otlpmetrichttp.WithEndpointURL(endpoint))
+	}
+	return otlpmetricgrpc.New(ctx, otlpmetricgrpc.WithEndpointURL(endpoint))
+}
+
+func (sme *smbMetricsExporter) newOTLPResource(
+	ctx context.Context) (*resource.Resource, error) {
+	vers, _ := ResolveVersions(ctx, nil)
+	attrs := []attribute.KeyValue{
+		semconv.ServiceName("smbmetrics"),
+		semconv.ServiceVersion(vers.Version),
+	}
+	podid := GetSelfPodID()
+	if podid.Name != "" {
+		attrs = append(attrs, semconv.K8SPodName(podid.Name))
+	}
+	if podid.Namespace != "" {
+		attrs = append(attrs, semconv.K8SNamespaceName(podid.Namespace))
+	}
+	optional := map[string]string{
+		"smbmetrics.commit_id": vers.CommitID,
+		"samba.image":          vers.SambaImage,
+		"samba.version":        vers.SambaVersion,
+		"ctdb.version":         vers.CtdbVersion,
+	}
+	for key, val := range optional {
+		if val != "" {
+			attrs = append(attrs, attribute.String(key, val))
+		}
+	}
+	return resource.New(ctx,
+		resource.WithFromEnv(),
+		resource.WithTelemetrySDK(),
+		resource.WithSchemaURL(semconv.SchemaURL),
+		resource.WithAttributes(attrs...))
+}
+
+// newOTLPMeterProvider creates a meter provider which periodically pushes
+// the contents of exporter's registry to OTLP endpoint. Metrics are gathered
+// through the same collectors which serve Prometheus scrapes.
+func (sme *smbMetricsExporter) newOTLPMeterProvider(
+	ctx context.Context) (*sdkmetric.MeterProvider, error) {
+	exporter, err := sme.newOTLPExporter(ctx)
+	if err != nil {
+		return nil, err
+	}
+	res, err := sme.newOTLPResource(ctx)
+	if err != nil {
+		sme.log.V(1).Info("partial OTLP resource", "err", err)
+	}
+	producer := prometheusbridge.NewMetricProducer(
+		prometheusbridge.WithGatherer(sme.gatherer()))
+	reader := sdkmetric.NewPeriodicReader(exporter,
+		sdkmetric.WithInterval(sme.cfg.OTLPInterval),
+		sdkmetric.WithProducer(producer))
+	return sdkmetric.NewMeterProvider(
+		sdkmetric.WithReader(reader),
+		sdkmetric.WithResource(res)), nil
+}
+
+// pushOTLP pushes metrics to OTLP endpoint until ctx is done, followed by a
+// final push upon shutdown.
+func (sme *smbMetricsExporter) pushOTLP(ctx context.Context) error {
+	provider, err := sme.newOTLPMeterProvider(ctx)
+	if err != nil {
+		sme.log.Error(err, "failed to create OTLP exporter",
+			"endpoint", sme.cfg.OTLPEndpoint)
+		return err
+	}
+	sme.log.Info("push OTLP metrics",
+		"endpoint", sme.cfg.OTLPEndpoint, "interval", sme.cfg.OTLPInterval)
+	<-ctx.Done()
+
+	shutdownCtx, cancel := context.WithTimeout(
+		context.WithoutCancel(ctx), sme.cfg.ShutdownTimeout)
+	defer cancel()
+	err = provider.Shutdown(shutdownCtx)
+	if err != nil {
+		sme.log.Error(err, "OTLP exporter shutdown failure")
+	}
+	return err
+}
@@ -0,0 +1,111 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package metrics
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/go-logr/logr"
+	"github.com/stretchr/testify/assert"
+	colmetricspb "go.opentelemetry.io/proto/otlp/collector/metrics/v1"
+	"google.golang.org/protobuf/proto"
+)
+
+// newOTLPReceiver starts a minimal stand-in of OTLP/HTTP metrics receiver,
+// which forwards decoded export requests to the returned channel.
+func newOTLPReceiver(t *testing.T) (
+	*httptest.Server, <-chan *colmetricspb.ExportMetricsServiceRequest) {
+	reqs := make(chan *colmetricspb.ExportMetricsServiceRequest, 16)
+	server := httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			body, err := io.ReadAll(r.Body)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			req := &colmetricspb.ExportMetricsServiceRequest{}
+			if err = proto.Unmarshal(body, req); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			reqs <- req
+			resp, _ := proto.Marshal(&colmetricspb.ExportMetricsServiceResponse{})
+			w.Header().Set("Content-Type", "application/x-protobuf")
+			_, _ = w.Write(resp)
+		}))
+	t.Cleanup(server.Close)
+	return server, reqs
+}
+
+func TestPushOTLP(t *testing.T) {
+	server, reqs := newOTLPReceiver(t)
+	sme := newTestExporter(t, &ExporterConfig{
+		OTLPEndpoint: server.URL + "/v1/metrics",
+		OTLPProtocol: OTLPProtocolHTTP,
+		OTLPInterval: time.Hour,
+	})
+
+	// Final push is expected upon shutdown, even before interval elapses
+	ctx, cancel := context.WithCancel(t.Context())
+	cancel()
+	err := sme.pushOTLP(ctx)
+	assert.NoError(t, err)
+
+	var req *colmetricspb.ExportMetricsServiceRequest
+	select {
+	case req = <-reqs:
+	default:
+	}
+	assert.NotNil(t, req)
+	if req == nil {
+		return
+	}
+	assert.Len(t, req.ResourceMetrics, 1)
+	resAttrs := map[string]string{}
+	for _, kv := range req.ResourceMetrics[0].Resource.Attributes {
+		resAttrs[kv.Key] = kv.Value.GetStringValue()
+	}
+	assert.Equal(t, resAttrs["service.name"], "smbmetrics")
+	assert.Equal(t, resAttrs["k8s.pod.name"], "smb-0")
+	assert.Equal(t, resAttrs["k8s.namespace.name"], "samba")
+
+	found := false
+	for _, sm := range req.ResourceMetrics[0].ScopeMetrics {
+		for _, m := range sm.Metrics {
+			if m.Name != "smb_test_requests_total" {
+				continue
+			}
+			found = true
+			points := m.GetSum().GetDataPoints()
+			assert.Len(t, points, 1)
+			assert.Equal(t, points[0].GetAsDouble(), float64(3))
+			assert.Equal(t, points[0].Attributes[0].Key, "share")
+			assert.Equal(t, points[0].Attributes[0].Value.GetStringValue(), "share1")
+		}
+	}
+	assert.True(t, found)
+}
+
+func TestPushOTLPUnknownProtocol(t *testing.T) {
+	sme := newSmbMetricsExporter(t.Context(), logr.Discard(), &ExporterConfig{
+		OTLPEndpoint: "http://localhost:4318",
+		OTLPProtocol: "udp",
+		OTLPInterval: time.Hour,
+	})
+	err := sme.pushOTLP(t.Context())
+	assert.Error(t, err)
+}
+
+func TestRunSmbMetricsExporterUnknownOTLPProtocol(t *testing.T) {
+	err := RunSmbMetricsExporter(t.Context(), logr.Discard(), &ExporterConfig{
+		OTLPEndpoint: "http://localhost:4318",
+		OTLPProtocol: "udp",
+	})
+	assert.Error(t, err)
+	assert.NoError(t, ValidateOTLPProtocol(OTLPProtocolHTTP))
+}
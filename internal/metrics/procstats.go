@@ -0,0 +1,146 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package metrics
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/procfs"
+)
+
+// SMBDProcessStats represents the resource usage of a single smbd process
+type SMBDProcessStats struct {
+	PID           string
+	ResidentBytes uint64
+	CPUSeconds    float64
+	OpenFDs       int
+	StartTime     time.Time
+	// HasIO is false when I/O accounting is not accessible
+	HasIO      bool
+	ReadBytes  uint64
+	WriteBytes uint64
+}
+
+// ReadSMBDProcessStats reads resource usage of process pid from the proc
+// file-system. The process must be visible in exporter's PID namespace.
+func ReadSMBDProcessStats(fs procfs.FS, pid string) (*SMBDProcessStats, error) {
+	id, err := strconv.Atoi(pid)
+	if err != nil {
+		return nil, err
+	}
+	proc, err := fs.Proc(id)
+	if err != nil {
+		return nil, err
+	}
+	stat, err := proc.Stat()
+	if err != nil {
+		return nil, err
+	}
+	status, err := proc.NewStatus()
+	if err != nil {
+		return nil, err
+	}
+	fds, err := proc.FileDescriptorsLen()
+	if err != nil {
+		return nil, err
+	}
+	startTime, err := stat.StartTime()
+	if err != nil {
+		return nil, err
+	}
+	ret := &SMBDProcessStats{
+		PID:           pid,
+		ResidentBytes: status.VmRSS,
+		CPUSeconds:    stat.CPUTime(),
+		OpenFDs:       fds,
+		StartTime:     secondsToTime(startTime),
+	}
+	// Reading I/O accounting of other processes requires ptrace access
+	if io, err := proc.IO(); err == nil {
+		ret.HasIO = true
+		ret.ReadBytes = io.ReadBytes
+		ret.WriteBytes = io.WriteBytes
+	}
+	return ret, nil
+}
+
+func secondsToTime(seconds float64) time.Time {
+	return time.Unix(0, int64(seconds*float64(time.Second)))
+}
+
+type smbProcessStatsCollector struct {
+	smbCollector
+}
+
+func (col *smbProcessStatsCollector) Collect(ch chan<- prometheus.Metric) {
+	smbInfo, err := col.sme.sharedSMBInfo()
+	if err != nil {
+		return
+	}
+	// Processes of other CTDB nodes are not visible locally
+	smbInfo.RestrictToVNN(col.sme.localVNN())
+	fs, err := procfs.NewDefaultFS()
+	if err != nil {
+		col.sme.log.V(1).Info("failed to access proc file-system", "err", err)
+		return
+	}
+	for pid, machine := range smbInfo.MapPIDToMachine() {
+		stats, err := ReadSMBDProcessStats(fs, pid)
+		if err != nil {
+			col.sme.log.V(1).Info("failed to read smbd process stats",
+				"pid", pid, "err", err)
+			continue
+		}
+		col.collectProcessStats(ch, stats, machine)
+	}
+}
+
+func (col *smbProcessStatsCollector) collectProcessStats(
+	ch chan<- prometheus.Metric, stats *SMBDProcessStats, machine string) {
+	ch <- prometheus.MustNewConstMetric(col.dsc[0],
+		prometheus.GaugeValue, float64(stats.ResidentBytes),
+		stats.PID, machine)
+	ch <- newCounterMetric(col.dsc[1], stats.CPUSeconds, stats.StartTime,
+		stats.PID, machine)
+	ch <- prometheus.MustNewConstMetric(col.dsc[2],
+		prometheus.GaugeValue, float64(stats.OpenFDs),
+		stats.PID, machine)
+	if !stats.HasIO {
+		return
+	}
+	ch <- newCounterMetric(col.dsc[3], float64(stats.ReadBytes),
+		stats.StartTime, stats.PID, machine)
+	ch <- newCounterMetric(col.dsc[4], float64(stats.WriteBytes),
+		stats.StartTime, stats.PID, machine)
+}
+
+func (sme *smbMetricsExporter) newSMBProcessStatsCollector() prometheus.Collector {
+	labels := []string{"pid", "machine"}
+	col := &smbProcessStatsCollector{}
+	col.sme = sme
+	col.dsc = []*prometheus.Desc{
+		prometheus.NewDesc(
+			collectorName("smbd_process", "resident_memory_bytes"),
+			"Resident memory size in bytes of smbd process",
+			labels, nil),
+		prometheus.NewDesc(
+			collectorName("smbd_process", "cpu_seconds_total"),
+			"Total user and system CPU time in seconds of smbd process",
+			labels, nil),
+		prometheus.NewDesc(
+			collectorName("smbd_process", "open_fds"),
+			"Number of open file descriptors of smbd process",
+			labels, nil),
+		prometheus.NewDesc(
+			collectorName("smbd_process", "read_bytes_total"),
+			"Number of bytes read from storage by smbd process",
+			labels, nil),
+		prometheus.NewDesc(
+			collectorName("smbd_process", "write_bytes_total"),
+			"Number of bytes written to storage by smbd process",
+			labels, nil),
+	}
+	return col
+}
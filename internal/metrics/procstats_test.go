@@ -0,0 +1,28 @@
+// SPDX-License-Identifier: Apache-2.0
+
+//go:build linux
+
+package metrics
+
+import (
+	"os"
+	"strconv"
+	"testing"
+
+	"github.com/prometheus/procfs"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReadSMBDProcessStats(t *testing.T) {
+	fs, err := procfs.NewDefaultFS()
+	assert.NoError(t, err)
+	pid := strconv.Itoa(os.Getpid())
+	stats, err := ReadSMBDProcessStats(fs, pid)
+	assert.NoError(t, err)
+	assert.Equal(t, stats.PID, pid)
+	assert.Greater(t, stats.ResidentBytes, uint64(0))
+	assert.Greater(t, stats.OpenFDs, 0)
+
+	_, err = ReadSMBDProcessStats(fs, "")
+	assert.Error(t, err)
+}
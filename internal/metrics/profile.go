@@ -0,0 +1,322 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package metrics
+
+import (
+	"math"
+	"sort"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const (
+	// ProfileOtherClients is the client label value of the bucket which
+	// aggregates all clients which are not among the top talkers. Parentheses
+	// are valid in neither host names nor IP addresses, so that it can not
+	// collide with the label value of a real client.
+	ProfileOtherClients = "(other)"
+)
+
+// SMBProfileShareClient identifies the per-share profile entries of a
+// single client
+type SMBProfileShareClient struct {
+	Share  string
+	Client string
+}
+
+// extendedEntries returns the extended (per-share) profile entries, or none
+// when there is no profile
+func (profile *SMBProfile) extendedEntries() map[string]*SMBProfileShare {
+	if profile == nil {
+		return nil
+	}
+	return profile.Extended
+}
+
+// AggregateExtended sums the extended (per-share) profile entries of each
+// pair of share and client over all connections (smbd processes and
+// connection indexes) which serve them. Entries with unparsable keys are
+// dropped.
+func (profile *SMBProfile) AggregateExtended() map[SMBProfileShareClient]*SMBProfileShare {
+	ret := map[SMBProfileShareClient]*SMBProfileShare{}
+	for key, extended := range profile.Extended {
+		pkey, err := ParseSMBProfileExtendedKey(key)
+		if err != nil {
+			continue
+		}
+		sc := SMBProfileShareClient{Share: pkey.Share, Client: pkey.Client}
+		agg, found := ret[sc]
+		if !found {
+			agg = &SMBProfileShare{}
+			ret[sc] = agg
+		}
+		if extended.SMB2Calls != nil {
+			if agg.SMB2Calls == nil {
+				agg.SMB2Calls = &SMBProfileSMB2Calls{}
+			}
+			agg.SMB2Calls.Merge(extended.SMB2Calls)
+		}
+		if extended.SystemCalls != nil {
+			if agg.SystemCalls == nil {
+				agg.SystemCalls = &SMBProfileSyscalls{}
+			}
+			agg.SystemCalls.Merge(extended.SystemCalls)
+		}
+	}
+	return ret
+}
+
+// Merge accumulates the values of other SMB2 calls into smb2Calls
+func (smb2Calls *SMBProfileSMB2Calls) Merge(other *SMBProfileSMB2Calls) {
+	otherOps := other.Operations()
+	for op, pce := range smb2Calls.Operations() {
+		pce.merge(otherOps[op])
+	}
+}
+
+// Merge accumulates the values of other system calls into sysCalls. Only
+// operations which are exported as metrics are accumulated.
+func (sysCalls *SMBProfileSyscalls) Merge(other *SMBProfileSyscalls) {
+	otherIOOps := other.IOOperations()
+	for op, pioe := range sysCalls.IOOperations() {
+		pioe.merge(otherIOOps[op])
+	}
+	otherOps := other.Operations()
+	for op, pe := range sysCalls.Operations() {
+		pe.merge(otherOps[op])
+	}
+}
+
+func (pe *SMBProfileEntry) merge(other *SMBProfileEntry) {
+	pe.Count += other.Count
+	pe.Time += other.Time
+}
+
+func (pioe *SMBProfileIOEntry) merge(other *SMBProfileIOEntry) {
+	pioe.SMBProfileEntry.merge(&other.SMBProfileEntry)
+	pioe.Idle += other.Idle
+	pioe.Bytes += other.Bytes
+	pioe.Buckets = mergeProfileBuckets(pioe.Buckets, other.Buckets)
+}
+
+func (pce *SMBProfileCallEntry) merge(other *SMBProfileCallEntry) {
+	pce.SMBProfileEntry.merge(&other.SMBProfileEntry)
+	pce.Idle += other.Idle
+	pce.Inbytes += other.Inbytes
+	pce.Outbytes += other.Outbytes
+	pce.Buckets = mergeProfileBuckets(pce.Buckets, other.Buckets)
+}
+
+// mergeProfileBuckets sums the counts of buckets with same upper bound
+func mergeProfileBuckets(buckets, other []SMBProfileBucket) []SMBProfileBucket {
+	if len(other) == 0 {
+		return buckets
+	}
+	counts := map[float64]int{}
+	for _, bucket := range append(append([]SMBProfileBucket{}, buckets...), other...) {
+		counts[bucket.Le] += bucket.Count
+	}
+	ret := make([]SMBProfileBucket, 0, len(counts))
+	for le, count := range counts {
+		ret = append(ret, SMBProfileBucket{Le: le, Count: count})
+	}
+	sort.Slice(ret, func(i, j int) bool {
+		return ret[i].Le < ret[j].Le
+	})
+	return ret
+}
+
+// cumulativeProfileBuckets converts per-bucket counts into cumulative counts
+// keyed by upper bound, as expected by Prometheus histograms. Also returns
+// the total count of all buckets, including the implicit +Inf one.
+func cumulativeProfileBuckets(buckets []SMBProfileBucket) (map[float64]uint64, uint64) {
+	sorted := append([]SMBProfileBucket{}, buckets...)
+	sort.Slice(sorted, func(i, j int) bool {
+		return sorted[i].Le < sorted[j].Le
+	})
+	ret := map[float64]uint64{}
+	total := uint64(0)
+	for _, bucket := range sorted {
+		total += uint64(max(bucket.Count, 0))
+		if !math.IsInf(bucket.Le, +1) {
+			ret[bucket.Le] = total
+		}
+	}
+	return ret, total
+}
+
+// profileLatencyHistogram returns a histogram metric of profile entry with
+// latency buckets, or nil when entry has none
+func profileLatencyHistogram(desc *prometheus.Desc, buckets []SMBProfileBucket,
+	count, sum int, created time.Time, labelValues ...string) prometheus.Metric {
+	if len(buckets) == 0 {
+		return nil
+	}
+	cumulative, total := cumulativeProfileBuckets(buckets)
+	count64 := max(total, uint64(max(count, 0)))
+	if created.IsZero() {
+		return prometheus.MustNewConstHistogram(desc,
+			count64, float64(sum), cumulative, labelValues...)
+	}
+	return prometheus.MustNewConstHistogramWithCreatedTimestamp(desc,
+		count64, float64(sum), cumulative, created, labelValues...)
+}
+
+// SMBProfileTotals represents SMB2 profile entries summed over all
+// operations
+type SMBProfileTotals struct {
+	Count    int
+	Time     int
+	Inbytes  int
+	Outbytes int
+}
+
+// Add accumulates the values of pce into totals
+func (totals *SMBProfileTotals) Add(pce *SMBProfileCallEntry) {
+	totals.Count += pce.Count
+	totals.Time += pce.Time
+	totals.Inbytes += pce.Inbytes
+	totals.Outbytes += pce.Outbytes
+}
+
+// Merge accumulates the values of other totals into totals
+func (totals *SMBProfileTotals) Merge(other *SMBProfileTotals) {
+	totals.Count += other.Count
+	totals.Time += other.Time
+	totals.Inbytes += other.Inbytes
+	totals.Outbytes += other.Outbytes
+}
+
+// Volume returns the number of bytes transferred in both directions
+func (totals *SMBProfileTotals) Volume() int {
+	return totals.Inbytes + totals.Outbytes
+}
+
+// Totals sums the SMB2 profile entries of all operations
+func (smb2Calls *SMBProfileSMB2Calls) Totals() *SMBProfileTotals {
+	totals := &SMBProfileTotals{}
+	for _, pce := range smb2Calls.Operations() {
+		totals.Add(pce)
+	}
+	return totals
+}
+
+// MapClientToTotals sums the extended (per-share) SMB2 profile entries of
+// each client, over all shares and operations
+func (profile *SMBProfile) MapClientToTotals() map[string]*SMBProfileTotals {
+	return profile.mapExtendedTotals(func(_, client string) string {
+		return client
+	})
+}
+
+// MapShareToTotals sums the extended (per-share) SMB2 profile entries of
+// each share, over all clients and operations
+func (profile *SMBProfile) MapShareToTotals() map[string]*SMBProfileTotals {
+	return profile.mapExtendedTotals(func(shareName, _ string) string {
+		return shareName
+	})
+}
+
+func (profile *SMBProfile) mapExtendedTotals(
+	keyOf func(shareName, client string) string) map[string]*SMBProfileTotals {
+	ret := map[string]*SMBProfileTotals{}
+	for key, extended := range profile.Extended {
+		pkey, err := ParseSMBProfileExtendedKey(key)
+		if err != nil || extended.SMB2Calls == nil {
+			continue
+		}
+		k := keyOf(pkey.Share, pkey.Client)
+		totals, found := ret[k]
+		if !found {
+			totals = &SMBProfileTotals{}
+			ret[k] = totals
+		}
+		totals.Merge(extended.SMB2Calls.Totals())
+	}
+	return ret
+}
+
+// TopProfileTotals retains the n entries with highest volume and merges all
+// others into a single ProfileOtherClients entry (present only if any entry
+// was merged)
+func TopProfileTotals(
+	totals map[string]*SMBProfileTotals, n int) map[string]*SMBProfileTotals {
+	keys := make([]string, 0, len(totals))
+	for key := range totals {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		vi, vj := totals[keys[i]].Volume(), totals[keys[j]].Volume()
+		if vi != vj {
+			return vi > vj
+		}
+		return keys[i] < keys[j]
+	})
+	ret := map[string]*SMBProfileTotals{}
+	for i, key := range keys {
+		if i < n {
+			ret[key] = totals[key]
+			continue
+		}
+		other, found := ret[ProfileOtherClients]
+		if !found {
+			other = &SMBProfileTotals{}
+			ret[ProfileOtherClients] = other
+		}
+		other.Merge(totals[key])
+	}
+	return ret
+}
+
+// collectTopTalkersMetrics exports aggregated per-client (top-N) and
+// per-share totals instead of per-operation metrics for each pair of
+// share and client
+func (col *smbProfileCollector) collectTopTalkersMetrics(
+	ch chan<- prometheus.Metric, profile *SMBProfile) {
+	clients := TopProfileTotals(profile.MapClientToTotals(),
+		col.sme.cfg.ProfileTopClients)
+	for client, totals := range clients {
+		col.collectTotalsMetrics(ch, col.clientTotals, client, totals)
+	}
+	for shareName, totals := range profile.MapShareToTotals() {
+		col.collectTotalsMetrics(ch, col.shareTotals, shareName, totals)
+	}
+}
+
+func (col *smbProfileCollector) collectTotalsMetrics(ch chan<- prometheus.Metric,
+	dsc []*prometheus.Desc, label string, totals *SMBProfileTotals) {
+	values := []int{
+		totals.Count,
+		totals.Inbytes,
+		totals.Outbytes,
+		totals.Time,
+	}
+	for i, val := range values {
+		ch <- prometheus.MustNewConstMetric(dsc[i],
+			prometheus.GaugeValue, float64(val),
+			col.netbiosName, label)
+	}
+}
+
+func newSMBProfileTotalsDescs(subsystem, label, what string) []*prometheus.Desc {
+	variableLabels := []string{"netbiosname", label}
+	return []*prometheus.Desc{
+		prometheus.NewDesc(
+			collectorName(subsystem, "request_total"),
+			"Total number of SMB2 requests per "+what,
+			variableLabels, nil),
+		prometheus.NewDesc(
+			collectorName(subsystem, "request_inbytes"),
+			"Bytes received for SMB2 requests per "+what,
+			variableLabels, nil),
+		prometheus.NewDesc(
+			collectorName(subsystem, "request_outbytes"),
+			"Bytes replied for SMB2 requests per "+what,
+			variableLabels, nil),
+		prometheus.NewDesc(
+			collectorName(subsystem, "request_duration_microseconds_sum"),
+			"Execution time in microseconds of SMB2 requests per "+what,
+			variableLabels, nil),
+	}
+}
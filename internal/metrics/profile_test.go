@@ -0,0 +1,219 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package metrics
+
+import (
+	"encoding/json"
+	"math"
+	"testing"
+	"time"
+
+	"github.com/go-logr/logr"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/stretchr/testify/assert"
+)
+
+// newTestSMBProfileDup returns the profile of smbstatus-profile-per-share2.json
+// with an additional connection of client 192.168.122.25 to same share
+func newTestSMBProfileDup(t *testing.T) *SMBProfile {
+	testdata := readTestData(t, "smbstatus-profile-per-share2.json")
+	profile, err := parseSMBProfile(testdata)
+	assert.NoError(t, err)
+	other, err := parseSMBProfile(testdata)
+	assert.NoError(t, err)
+	profile.Extended["smbshare:2308.1[192.168.122.25]"] =
+		other.Extended["smbshare:2308.0[192.168.122.25]"]
+	return profile
+}
+
+func TestSMBProfileMapTotals(t *testing.T) {
+	testdata := readTestData(t, "smbstatus-profile-per-share.json")
+	profile, err := parseSMBProfile(testdata)
+	assert.NoError(t, err)
+
+	clients := profile.MapClientToTotals()
+	assert.Equal(t, len(clients), 1)
+	totals := clients["192.168.122.108"]
+	assert.Equal(t, totals.Count, 74+63)
+	assert.Equal(t, totals.Inbytes, 9319+8039)
+	assert.Equal(t, totals.Outbytes, 12961+11074)
+	assert.Equal(t, totals.Time, 30043+19008)
+
+	shares := profile.MapShareToTotals()
+	assert.Equal(t, len(shares), 2)
+	assert.Equal(t, shares["smbshare1"].Count, 74)
+	assert.Equal(t, shares["smbshare2"].Volume(), 8039+11074)
+}
+
+func TestTopProfileTotals(t *testing.T) {
+	testdata := readTestData(t, "smbstatus-profile-per-share2.json")
+	profile, err := parseSMBProfile(testdata)
+	assert.NoError(t, err)
+	clients := profile.MapClientToTotals()
+	assert.Equal(t, len(clients), 2)
+
+	top := TopProfileTotals(clients, 1)
+	assert.Equal(t, len(top), 2)
+	assert.Equal(t, top["192.168.122.25"].Count, 49)
+	assert.Equal(t, top[ProfileOtherClients].Count, 43)
+	assert.Equal(t, top[ProfileOtherClients].Time, 63190)
+
+	top = TopProfileTotals(clients, 2)
+	assert.Equal(t, len(top), 2)
+	assert.NotContains(t, top, ProfileOtherClients)
+
+	top = TopProfileTotals(clients, 0)
+	assert.Equal(t, len(top), 1)
+	assert.Equal(t, top[ProfileOtherClients].Count, 49+43)
+
+	// A client whose host name is 'other' is not merged with other clients
+	clients["other"] = clients["192.168.122.25"]
+	delete(clients, "192.168.122.25")
+	top = TopProfileTotals(clients, 1)
+	assert.Equal(t, len(top), 2)
+	assert.Equal(t, top["other"].Count, 49)
+	assert.Equal(t, top[ProfileOtherClients].Count, 43)
+}
+
+func TestSMBProfileAggregateExtended(t *testing.T) {
+	profile := newTestSMBProfileDup(t)
+	single := profile.Extended["smbshare:2308.0[192.168.122.25]"]
+	readCount := single.SMB2Calls.Read.Count
+	readBytes := single.SystemCalls.PRead.Bytes
+
+	aggregated := profile.AggregateExtended()
+	assert.Equal(t, len(aggregated), 2)
+	agg := aggregated[SMBProfileShareClient{
+		Share:  "smbshare",
+		Client: "192.168.122.25",
+	}]
+	assert.NotNil(t, agg)
+	assert.Equal(t, agg.SMB2Calls.Read.Count, 2*readCount)
+	assert.Equal(t, agg.SMB2Calls.Totals().Count, 2*49)
+	assert.Equal(t, agg.SystemCalls.PRead.Bytes, 2*readBytes)
+	// Source entries are left intact
+	assert.Equal(t, single.SMB2Calls.Read.Count, readCount)
+
+	agg = aggregated[SMBProfileShareClient{
+		Share:  "smbshare",
+		Client: "192.168.122.108",
+	}]
+	assert.NotNil(t, agg)
+	assert.Equal(t, agg.SMB2Calls.Totals().Count, 43)
+}
+
+func TestSMBProfileCollectorNoDuplicates(t *testing.T) {
+	profile := newTestSMBProfileDup(t)
+	sme := newSmbMetricsExporter(t.Context(), logr.Discard(), &ExporterConfig{})
+	col := sme.newSMBProfileCollector().(*smbProfileCollector)
+	ch := make(chan prometheus.Metric, 4096)
+	col.collectProfileMetrics(ch, profile, time.Time{})
+	close(ch)
+
+	seen := map[string]bool{}
+	for metric := range ch {
+		pb := &dto.Metric{}
+		assert.NoError(t, metric.Write(pb))
+		id := metric.Desc().String()
+		for _, label := range pb.GetLabel() {
+			id += "," + label.GetName() + "=" + label.GetValue()
+		}
+		assert.False(t, seen[id], id)
+		seen[id] = true
+	}
+	assert.NotEmpty(t, seen)
+}
+
+func TestSMBProfileCollectorCounters(t *testing.T) {
+	testdata := readTestData(t, "smbstatus-profile-per-share2.json")
+	profile, err := parseSMBProfile(testdata)
+	assert.NoError(t, err)
+	created := time.Unix(1700000000, 0)
+	for _, counters := range []bool{false, true} {
+		sme := newSmbMetricsExporter(t.Context(), logr.Discard(),
+			&ExporterConfig{ProfileCounters: counters})
+		col := sme.newSMBProfileCollector().(*smbProfileCollector)
+		ch := make(chan prometheus.Metric, 4096)
+		col.collectProfileMetrics(ch, profile, created)
+		close(ch)
+		totals, withCreated := 0, 0
+		for metric := range ch {
+			if metric.Desc() != col.dsc[0] {
+				continue
+			}
+			pb := &dto.Metric{}
+			assert.NoError(t, metric.Write(pb))
+			if counters {
+				assert.NotNil(t, pb.GetCounter())
+				if ts := pb.GetCounter().GetCreatedTimestamp(); ts != nil {
+					assert.Equal(t, ts.AsTime(), created.UTC())
+					withCreated++
+				}
+			} else {
+				assert.NotNil(t, pb.GetGauge())
+				assert.Nil(t, pb.GetCounter())
+			}
+			totals++
+		}
+		assert.NotZero(t, totals)
+		assert.Equal(t, withCreated > 0, counters)
+	}
+}
+
+func TestSMBProfileLatencyHistograms(t *testing.T) {
+	testdata := readTestData(t, "smbstatus-profile-buckets.json")
+	profile, err := parseSMBProfile(testdata)
+	assert.NoError(t, err)
+	read := profile.SMB2Calls.Read
+	assert.Equal(t, len(read.Buckets), 3)
+	assert.Equal(t, read.Buckets[0], SMBProfileBucket{Le: 1000, Count: 2})
+	assert.True(t, math.IsInf(read.Buckets[2].Le, +1))
+	assert.Empty(t, profile.SMB2Calls.Write.Buckets)
+
+	dat, err := json.Marshal(read.Buckets[2])
+	assert.NoError(t, err)
+	assert.JSONEq(t, string(dat), `{"le": "+Inf", "count": 1}`)
+
+	cumulative, total := cumulativeProfileBuckets(read.Buckets)
+	assert.Equal(t, cumulative, map[float64]uint64{1000: 2, 10000: 5})
+	assert.Equal(t, total, uint64(6))
+
+	sme := newSmbMetricsExporter(t.Context(), logr.Discard(), &ExporterConfig{})
+	col := sme.newSMBProfileCollector().(*smbProfileCollector)
+	ch := make(chan prometheus.Metric, 4096)
+	col.collectProfileMetrics(ch, profile, time.Time{})
+	close(ch)
+	histograms := map[string]*dto.Histogram{}
+	for metric := range ch {
+		pb := &dto.Metric{}
+		assert.NoError(t, metric.Write(pb))
+		if pb.Histogram == nil {
+			continue
+		}
+		for _, label := range pb.GetLabel() {
+			if label.GetName() == "operation" {
+				histograms[label.GetValue()] = pb.Histogram
+			}
+		}
+	}
+	assert.Equal(t, len(histograms), 2)
+	assert.Equal(t, histograms["read"].GetSampleCount(), uint64(6))
+	assert.Equal(t, histograms["read"].GetSampleSum(), float64(read.Time))
+	assert.Equal(t, len(histograms["read"].GetBucket()), 2)
+	assert.Equal(t, histograms["pread"].GetSampleCount(), uint64(4))
+	assert.Equal(t, histograms["pread"].GetBucket()[1].GetCumulativeCount(),
+		uint64(4))
+}
+
+func TestMergeProfileBuckets(t *testing.T) {
+	merged := mergeProfileBuckets(
+		[]SMBProfileBucket{{Le: 100, Count: 1}, {Le: 1000, Count: 2}},
+		[]SMBProfileBucket{{Le: 1000, Count: 3}, {Le: math.Inf(+1), Count: 1}})
+	assert.Equal(t, merged, []SMBProfileBucket{
+		{Le: 100, Count: 1},
+		{Le: 1000, Count: 5},
+		{Le: math.Inf(+1), Count: 1},
+	})
+	assert.Nil(t, mergeProfileBuckets(nil, nil))
+}
@@ -0,0 +1,138 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package metrics
+
+import (
+	"context"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/push"
+)
+
+var (
+	// DefaultPushInterval is the default interval between consecutive
+	// pushes of metrics to Pushgateway or remote-write endpoint
+	DefaultPushInterval = 30 * time.Second
+	// DefaultPushJob is the job name used when pushing metrics
+	DefaultPushJob = "smbmetrics"
+)
+
+// metricsPusher sends the current contents of exporter's registry to a
+// remote destination.
+type metricsPusher interface {
+	push(ctx context.Context) error
+}
+
+// pushGroupingLabels returns the labels which identify the pushing instance:
+// name and namespace of self pod when running within Kubernetes, or local
+// host name otherwise.
+func pushGroupingLabels() map[string]string {
+	labels := map[string]string{}
+	podid := GetSelfPodID()
+	if podid.Name != "" {
+		labels["pod"] = podid.Name
+	}
+	if podid.Namespace != "" {
+		labels["namespace"] = podid.Namespace
+	}
+	if len(labels) == 0 {
+		if hostname, err := os.Hostname(); err == nil {
+			labels["instance"] = hostname
+		}
+	}
+	return labels
+}
+
+func (sme *smbMetricsExporter) pushHTTPClient() *http.Client {
+	return &http.Client{Timeout: sme.cfg.PushInterval}
+}
+
+func (sme *smbMetricsExporter) newPushgatewayPusher() metricsPusher {
+	pusher := push.New(sme.cfg.PushgatewayURL, DefaultPushJob).
+		Gatherer(sme.gatherer()).
+		Client(sme.pushHTTPClient())
+	for name, value := range pushGroupingLabels() {
+		pusher = pusher.Grouping(name, value)
+	}
+	return &pushgatewayPusher{pusher: pusher}
+}
+
+type pushgatewayPusher struct {
+	pusher *push.Pusher
+}
+
+func (pp *pushgatewayPusher) push(ctx context.Context) error {
+	// Replace all metrics of own grouping key, so that series which are no
+	// longer collected do not linger within Pushgateway
+	return pp.pusher.PushContext(ctx)
+}
+
+// runPusher pushes metrics on every interval until ctx is done, followed by a
+// final push upon shutdown.
+func (sme *smbMetricsExporter) runPusher(
+	ctx context.Context, pusher metricsPusher, dest string) {
+	sme.log.Info("push metrics", "dest", dest, "interval", sme.cfg.PushInterval)
+	ticker := time.NewTicker(sme.cfg.PushInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			if err := pusher.push(ctx); err != nil {
+				sme.log.Error(err, "failed to push metrics", "dest", dest)
+			}
+		case <-ctx.Done():
+			shutdownCtx, cancel := context.WithTimeout(
+				context.WithoutCancel(ctx), sme.cfg.ShutdownTimeout)
+			defer cancel()
+			if err := pusher.push(shutdownCtx); err != nil {
+				sme.log.Error(err, "failed final push of metrics", "dest", dest)
+			}
+			return
+		}
+	}
+}
+
+// startPushers starts the configured push-mode exporters, which run until ctx
+// is done and perform a final push upon shutdown.
+func (sme *smbMetricsExporter) startPushers(ctx context.Context) {
+	if sme.cfg.OTLPEndpoint != "" {
+		sme.goPusher(func() {
+			_ = sme.pushOTLP(ctx)
+		})
+	}
+	if sme.cfg.PushgatewayURL != "" {
+		pusher := sme.newPushgatewayPusher()
+		sme.goPusher(func() {
+			sme.runPusher(ctx, pusher, sme.cfg.PushgatewayURL)
+		})
+	}
+	if sme.cfg.RemoteWriteURL != "" {
+		pusher := sme.newRemoteWritePusher()
+		sme.goPusher(func() {
+			sme.runPusher(ctx, pusher, sme.cfg.RemoteWriteURL)
+		})
+	}
+}
+
+func (sme *smbMetricsExporter) goPusher(fn func()) {
+	sme.pushers.Add(1)
+	go func() {
+		defer sme.pushers.Done()
+		fn()
+	}()
+}
+
+func (sme *smbMetricsExporter) waitPushers(ctx context.Context) {
+	done := make(chan struct{})
+	go func() {
+		sme.pushers.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-ctx.Done():
+		sme.log.Info("push-mode exporters did not complete in time")
+	}
+}
@@ -0,0 +1,171 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package metrics
+
+import (
+	"context"
+	"io"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/klauspost/compress/s2"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+type pushRequest struct {
+	method string
+	path   string
+	header http.Header
+	body   []byte
+}
+
+func newPushReceiver(t *testing.T) (*httptest.Server, <-chan pushRequest) {
+	reqs := make(chan pushRequest, 16)
+	server := httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			body, _ := io.ReadAll(r.Body)
+			reqs <- pushRequest{
+				method: r.Method,
+				path:   r.URL.Path,
+				header: r.Header,
+				body:   body,
+			}
+			w.WriteHeader(http.StatusOK)
+		}))
+	t.Cleanup(server.Close)
+	return server, reqs
+}
+
+func newTestPushExporter(t *testing.T, cfg *ExporterConfig) *smbMetricsExporter {
+	cfg.PushInterval = time.Hour
+	return newTestExporter(t, cfg)
+}
+
+func TestPushgatewayFinalPush(t *testing.T) {
+	server, reqs := newPushReceiver(t)
+	sme := newTestPushExporter(t, &ExporterConfig{PushgatewayURL: server.URL})
+
+	ctx, cancel := context.WithCancel(t.Context())
+	cancel()
+	sme.runPusher(ctx, sme.newPushgatewayPusher(), server.URL)
+
+	req := <-reqs
+	assert.Equal(t, req.method, http.MethodPut)
+	assert.True(t, strings.HasPrefix(req.path, "/metrics/job/smbmetrics/"))
+	assert.Contains(t, req.path, "/pod/smb-0")
+	assert.Contains(t, req.path, "/namespace/samba")
+}
+
+func TestRemoteWriteFinalPush(t *testing.T) {
+	server, reqs := newPushReceiver(t)
+	sme := newTestPushExporter(t, &ExporterConfig{RemoteWriteURL: server.URL})
+
+	ctx, cancel := context.WithCancel(t.Context())
+	cancel()
+	sme.runPusher(ctx, sme.newRemoteWritePusher(), server.URL)
+
+	req := <-reqs
+	assert.Equal(t, req.method, http.MethodPost)
+	assert.Equal(t, req.header.Get("Content-Encoding"), "snappy")
+	assert.Equal(t, req.header.Get("X-Prometheus-Remote-Write-Version"), "0.1.0")
+	data, err := s2.Decode(nil, req.body)
+	assert.NoError(t, err)
+	series := decodeRemoteWriteRequest(t, data)
+	assert.Len(t, series, 1)
+	assert.Equal(t, series[0].Labels, []RemoteWriteLabel{
+		{Name: "__name__", Value: "smb_test_requests_total"},
+		{Name: "job", Value: "smbmetrics"},
+		{Name: "namespace", Value: "samba"},
+		{Name: "pod", Value: "smb-0"},
+		{Name: "share", Value: "share1"},
+	})
+	assert.Equal(t, series[0].Value, float64(3))
+	assert.Greater(t, series[0].Timestamp, int64(0))
+}
+
+func TestMakeRemoteWriteSeriesHistogram(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	hist := prometheus.NewHistogram(prometheus.HistogramOpts{
+		Name:    collectorName("test", "latency_microseconds"),
+		Help:    "Test histogram",
+		Buckets: []float64{10, 100},
+	})
+	hist.Observe(5)
+	hist.Observe(50)
+	hist.Observe(500)
+	reg.MustRegister(hist)
+	mfs, err := reg.Gather()
+	assert.NoError(t, err)
+
+	now := time.UnixMilli(1700000000000)
+	series := MakeRemoteWriteSeries(mfs, map[string]string{"job": "x"}, now)
+	values := map[string]float64{}
+	for _, s := range series {
+		key := ""
+		for _, label := range s.Labels {
+			key += label.Name + "=" + label.Value + ","
+		}
+		values[key] = s.Value
+		assert.Equal(t, s.Timestamp, now.UnixMilli())
+	}
+	name := "__name__=smb_test_latency_microseconds"
+	assert.Equal(t, values, map[string]float64{
+		name + "_bucket,job=x,le=10,":   1,
+		name + "_bucket,job=x,le=100,":  2,
+		name + "_bucket,job=x,le=+Inf,": 3,
+		name + "_sum,job=x,":            555,
+		name + "_count,job=x,":          3,
+	})
+}
+
+// decodeRemoteWriteRequest is a minimal decoder of remote-write WriteRequest
+// message, sufficient for verifying the output of EncodeRemoteWriteRequest.
+func decodeRemoteWriteRequest(t *testing.T, data []byte) []RemoteWriteSeries {
+	series := []RemoteWriteSeries{}
+	for _, ts := range consumeProtoFields(t, data)[1] {
+		s := RemoteWriteSeries{}
+		fields := consumeProtoFields(t, ts.([]byte))
+		for _, lb := range fields[1] {
+			label := consumeProtoFields(t, lb.([]byte))
+			s.Labels = append(s.Labels, RemoteWriteLabel{
+				Name:  string(label[1][0].([]byte)),
+				Value: string(label[2][0].([]byte)),
+			})
+		}
+		sample := consumeProtoFields(t, fields[2][0].([]byte))
+		s.Value = math.Float64frombits(sample[1][0].(uint64))
+		s.Timestamp = int64(sample[2][0].(uint64))
+		series = append(series, s)
+	}
+	return series
+}
+
+func consumeProtoFields(t *testing.T, data []byte) map[protowire.Number][]any {
+	fields := map[protowire.Number][]any{}
+	for len(data) > 0 {
+		num, typ, n := protowire.ConsumeTag(data)
+		assert.Greater(t, n, 0)
+		data = data[n:]
+		var val any
+		switch typ {
+		case protowire.BytesType:
+			val, n = protowire.ConsumeBytes(data)
+		case protowire.Fixed64Type:
+			val, n = protowire.ConsumeFixed64(data)
+		case protowire.VarintType:
+			val, n = protowire.ConsumeVarint(data)
+		default:
+			t.Fatalf("unexpected wire type %v", typ)
+		}
+		assert.Greater(t, n, 0)
+		data = data[n:]
+		fields[num] = append(fields[num], val)
+	}
+	return fields
+}
@@ -0,0 +1,251 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package metrics
+
+import (
+	"bufio"
+	"context"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	// DefaultQuotaMaxUsers is the default maximal number of users per share
+	// for which quota metrics are exported
+	DefaultQuotaMaxUsers = 100
+)
+
+// QuotaUsage represents the quota usage and limits of a single user, in
+// bytes and number of files
+type QuotaUsage struct {
+	User           string
+	BytesUsed      uint64
+	BytesSoftLimit uint64
+	BytesHardLimit uint64
+	FilesUsed      uint64
+	FilesSoftLimit uint64
+	FilesHardLimit uint64
+}
+
+// RunRepquota executes 'repquota' for the user quotas of file-system mounted
+// at mountPoint
+func RunRepquota(ctx context.Context, mountPoint string) ([]QuotaUsage, error) {
+	loc, err := locateExecutable("repquota", "/usr/sbin/repquota")
+	if err != nil {
+		return []QuotaUsage{}, err
+	}
+	dat, err := executeCommand(ctx, loc, "--user", "--output=csv", mountPoint)
+	if err != nil {
+		return []QuotaUsage{}, err
+	}
+	return parseRepquotaCSV(dat)
+}
+
+// parseRepquotaCSV parses the output of 'repquota --output=csv', in which
+// block values are in units of 1KiB
+func parseRepquotaCSV(data string) ([]QuotaUsage, error) {
+	ret := []QuotaUsage{}
+	records, err := csv.NewReader(strings.NewReader(data)).ReadAll()
+	if err != nil {
+		return ret, err
+	}
+	if len(records) == 0 {
+		return ret, errors.New("empty repquota output")
+	}
+	cols := map[string]int{}
+	for i, name := range records[0] {
+		cols[name] = i
+	}
+	for _, name := range []string{"BlockUsed", "FileUsed"} {
+		if _, found := cols[name]; !found {
+			return ret, fmt.Errorf("missing repquota column: %s", name)
+		}
+	}
+	value := func(record []string, name string) uint64 {
+		idx, found := cols[name]
+		if !found || idx >= len(record) {
+			return 0
+		}
+		val, _ := strconv.ParseUint(strings.TrimSpace(record[idx]), 10, 64)
+		return val
+	}
+	for _, record := range records[1:] {
+		if len(record) != len(records[0]) {
+			return ret, errors.New("malformed repquota record")
+		}
+		ret = append(ret, QuotaUsage{
+			User:           record[0],
+			BytesUsed:      value(record, "BlockUsed") * 1024,
+			BytesSoftLimit: value(record, "BlockSoftLimit") * 1024,
+			BytesHardLimit: value(record, "BlockHardLimit") * 1024,
+			FilesUsed:      value(record, "FileUsed"),
+			FilesSoftLimit: value(record, "FileSoftLimit"),
+			FilesHardLimit: value(record, "FileHardLimit"),
+		})
+	}
+	return ret, nil
+}
+
+// topQuotaUsers returns up to limit entries with highest bytes usage
+func topQuotaUsers(usage []QuotaUsage, limit int) []QuotaUsage {
+	ret := make([]QuotaUsage, len(usage))
+	copy(ret, usage)
+	sort.SliceStable(ret, func(i, j int) bool {
+		return ret[i].BytesUsed > ret[j].BytesUsed
+	})
+	if limit > 0 && len(ret) > limit {
+		ret = ret[:limit]
+	}
+	return ret
+}
+
+// ListMountPoints returns the mount points of current mount namespace
+func ListMountPoints() ([]string, error) {
+	file, err := os.Open("/proc/self/mountinfo")
+	if err != nil {
+		return []string{}, err
+	}
+	defer file.Close()
+	return parseMountInfo(file)
+}
+
+func parseMountInfo(r io.Reader) ([]string, error) {
+	ret := []string{}
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 5 {
+			continue
+		}
+		mountPoint, err := strconv.Unquote(`"` + fields[4] + `"`)
+		if err != nil {
+			mountPoint = fields[4]
+		}
+		ret = append(ret, mountPoint)
+	}
+	return ret, scanner.Err()
+}
+
+// findMountPoint returns the mount point which contains path
+func findMountPoint(mountPoints []string, path string) string {
+	path = filepath.Clean(path)
+	ret := ""
+	for _, mountPoint := range mountPoints {
+		rel, err := filepath.Rel(mountPoint, path)
+		if err != nil || strings.HasPrefix(rel, "..") {
+			continue
+		}
+		if len(mountPoint) > len(ret) {
+			ret = mountPoint
+		}
+	}
+	return ret
+}
+
+type smbQuotaCollector struct {
+	smbCollector
+}
+
+func (col *smbQuotaCollector) Collect(ch chan<- prometheus.Metric) {
+	shares, err := col.sme.smbConfShares()
+	if err != nil {
+		col.sme.log.V(1).Info("failed to resolve shares configuration",
+			"err", err)
+		return
+	}
+	mountPoints, err := ListMountPoints()
+	if err != nil {
+		col.sme.log.V(1).Info("failed to list mount points", "err", err)
+		return
+	}
+	// Shares on same file-system share the same quota report
+	reports := map[string][]QuotaUsage{}
+	for i := range shares {
+		share := &shares[i]
+		path := share.Path()
+		if path == "" || strings.Contains(path, "%") {
+			continue
+		}
+		mountPoint := findMountPoint(mountPoints, path)
+		usage, found := reports[mountPoint]
+		if !found {
+			usage, err = RunRepquota(col.sme.ctx, mountPoint)
+			if err != nil {
+				// Quota not enabled on this file-system
+				col.sme.log.V(1).Info("failed to query quota",
+					"mountPoint", mountPoint, "err", err)
+			}
+			reports[mountPoint] = usage
+		}
+		if len(usage) == 0 {
+			continue
+		}
+		col.collectShareQuota(ch, share.Name, usage)
+	}
+}
+
+func (col *smbQuotaCollector) collectShareQuota(
+	ch chan<- prometheus.Metric, service string, usage []QuotaUsage) {
+	ch <- prometheus.MustNewConstMetric(col.dsc[6],
+		prometheus.GaugeValue, float64(len(usage)), service)
+	for _, user := range topQuotaUsers(usage, col.sme.cfg.QuotaMaxUsers) {
+		values := []uint64{
+			user.BytesUsed,
+			user.BytesSoftLimit,
+			user.BytesHardLimit,
+			user.FilesUsed,
+			user.FilesSoftLimit,
+			user.FilesHardLimit,
+		}
+		for i, val := range values {
+			ch <- prometheus.MustNewConstMetric(col.dsc[i],
+				prometheus.GaugeValue, float64(val), service, user.User)
+		}
+	}
+}
+
+func (sme *smbMetricsExporter) newSMBQuotaCollector() prometheus.Collector {
+	labels := []string{"service", "user"}
+	col := &smbQuotaCollector{}
+	col.sme = sme
+	col.dsc = []*prometheus.Desc{
+		prometheus.NewDesc(
+			collectorName("quota", "used_bytes"),
+			"Bytes used by user on file-system hosting share",
+			labels, nil),
+		prometheus.NewDesc(
+			collectorName("quota", "soft_limit_bytes"),
+			"Bytes soft limit of user on file-system hosting share",
+			labels, nil),
+		prometheus.NewDesc(
+			collectorName("quota", "hard_limit_bytes"),
+			"Bytes hard limit of user on file-system hosting share",
+			labels, nil),
+		prometheus.NewDesc(
+			collectorName("quota", "used_files"),
+			"Number of files used by user on file-system hosting share",
+			labels, nil),
+		prometheus.NewDesc(
+			collectorName("quota", "soft_limit_files"),
+			"Files soft limit of user on file-system hosting share",
+			labels, nil),
+		prometheus.NewDesc(
+			collectorName("quota", "hard_limit_files"),
+			"Files hard limit of user on file-system hosting share",
+			labels, nil),
+		prometheus.NewDesc(
+			collectorName("quota", "users"),
+			"Number of users with quota entries on file-system hosting share",
+			[]string{"service"}, nil),
+	}
+	return col
+}
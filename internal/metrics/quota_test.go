@@ -0,0 +1,46 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package metrics
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseRepquotaCSV(t *testing.T) {
+	usage, err := parseRepquotaCSV(readTestData(t, "repquota.csv"))
+	assert.NoError(t, err)
+	assert.Equal(t, len(usage), 3)
+	assert.Equal(t, usage[1].User, "alice")
+	assert.Equal(t, usage[1].BytesUsed, uint64(1073741824))
+	assert.Equal(t, usage[1].BytesSoftLimit, uint64(2147483648))
+	assert.Equal(t, usage[1].BytesHardLimit, uint64(4294967296))
+	assert.Equal(t, usage[1].FilesUsed, uint64(1200))
+	assert.Equal(t, usage[2].FilesHardLimit, uint64(20000))
+
+	top := topQuotaUsers(usage, 2)
+	assert.Equal(t, len(top), 2)
+	assert.Equal(t, top[0].User, "bob")
+	assert.Equal(t, top[1].User, "alice")
+	assert.Equal(t, len(topQuotaUsers(usage, 0)), 3)
+
+	_, err = parseRepquotaCSV("")
+	assert.Error(t, err)
+	_, err = parseRepquotaCSV("*** Report for user quotas on device /dev/sdb1\n")
+	assert.Error(t, err)
+}
+
+func TestFindMountPoint(t *testing.T) {
+	mountPoints, err := parseMountInfo(strings.NewReader(
+		"22 1 253:0 / / rw,relatime shared:1 - xfs /dev/vda1 rw\n" +
+			"95 22 8:16 / /mnt/share rw,relatime shared:40 - xfs /dev/sdb rw,usrquota\n" +
+			"96 22 8:32 / /mnt/my\\040data rw,relatime shared:41 - ext4 /dev/sdc rw\n"))
+	assert.NoError(t, err)
+	assert.Equal(t, mountPoints, []string{"/", "/mnt/share", "/mnt/my data"})
+	assert.Equal(t, findMountPoint(mountPoints, "/mnt/share/dir1"), "/mnt/share")
+	assert.Equal(t, findMountPoint(mountPoints, "/mnt/share"), "/mnt/share")
+	assert.Equal(t, findMountPoint(mountPoints, "/mnt/shared"), "/")
+	assert.Equal(t, findMountPoint(mountPoints, "/mnt/my data/x/"), "/mnt/my data")
+}
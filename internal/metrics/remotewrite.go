@@ -0,0 +1,212 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package metrics
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/klauspost/compress/s2"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// RemoteWriteLabel is a name-value pair of remote-write time-series
+type RemoteWriteLabel struct {
+	Name  string
+	Value string
+}
+
+// RemoteWriteSeries is a single-sample time-series of remote-write request
+type RemoteWriteSeries struct {
+	Labels    []RemoteWriteLabel
+	Value     float64
+	Timestamp int64
+}
+
+// MakeRemoteWriteSeries flattens gathered metric families into time-series
+// with sorted labels, as expected by Prometheus remote-write protocol (v1).
+// Histograms and summaries are split into their classic component series.
+// External labels are added to each series, unless already present.
+func MakeRemoteWriteSeries(mfs []*dto.MetricFamily,
+	external map[string]string, now time.Time) []RemoteWriteSeries {
+	series := []RemoteWriteSeries{}
+	for _, mf := range mfs {
+		for _, m := range mf.GetMetric() {
+			rws := remoteWriteSeriesBuilder{
+				name:      mf.GetName(),
+				labels:    m.GetLabel(),
+				external:  external,
+				timestamp: now.UnixMilli(),
+			}
+			if m.TimestampMs != nil {
+				rws.timestamp = m.GetTimestampMs()
+			}
+			series = append(series, rws.build(mf.GetType(), m)...)
+		}
+	}
+	return series
+}
+
+type remoteWriteSeriesBuilder struct {
+	name      string
+	labels    []*dto.LabelPair
+	external  map[string]string
+	timestamp int64
+	series    []RemoteWriteSeries
+}
+
+func (rws *remoteWriteSeriesBuilder) build(
+	mtype dto.MetricType, m *dto.Metric) []RemoteWriteSeries {
+	switch mtype {
+	case dto.MetricType_COUNTER:
+		rws.add("", m.GetCounter().GetValue())
+	case dto.MetricType_GAUGE:
+		rws.add("", m.GetGauge().GetValue())
+	case dto.MetricType_UNTYPED:
+		rws.add("", m.GetUntyped().GetValue())
+	case dto.MetricType_SUMMARY:
+		summary := m.GetSummary()
+		for _, q := range summary.GetQuantile() {
+			rws.add("", q.GetValue(), "quantile", formatFloat(q.GetQuantile()))
+		}
+		rws.add("_sum", summary.GetSampleSum())
+		rws.add("_count", float64(summary.GetSampleCount()))
+	case dto.MetricType_HISTOGRAM, dto.MetricType_GAUGE_HISTOGRAM:
+		hist := m.GetHistogram()
+		hasInf := false
+		for _, b := range hist.GetBucket() {
+			hasInf = hasInf || math.IsInf(b.GetUpperBound(), 1)
+			rws.add("_bucket", float64(b.GetCumulativeCount()),
+				"le", formatFloat(b.GetUpperBound()))
+		}
+		if !hasInf {
+			rws.add("_bucket", float64(hist.GetSampleCount()),
+				"le", formatFloat(math.Inf(1)))
+		}
+		rws.add("_sum", hist.GetSampleSum())
+		rws.add("_count", float64(hist.GetSampleCount()))
+	}
+	return rws.series
+}
+
+func (rws *remoteWriteSeriesBuilder) add(
+	suffix string, value float64, extra ...string) {
+	labels := []RemoteWriteLabel{{Name: "__name__", Value: rws.name + suffix}}
+	present := map[string]bool{}
+	for _, lp := range rws.labels {
+		labels = append(labels,
+			RemoteWriteLabel{Name: lp.GetName(), Value: lp.GetValue()})
+		present[lp.GetName()] = true
+	}
+	for i := 0; i+1 < len(extra); i += 2 {
+		labels = append(labels, RemoteWriteLabel{Name: extra[i], Value: extra[i+1]})
+		present[extra[i]] = true
+	}
+	for name, value := range rws.external {
+		if !present[name] {
+			labels = append(labels, RemoteWriteLabel{Name: name, Value: value})
+		}
+	}
+	sort.Slice(labels, func(i, j int) bool {
+		return labels[i].Name < labels[j].Name
+	})
+	rws.series = append(rws.series, RemoteWriteSeries{
+		Labels:    labels,
+		Value:     value,
+		Timestamp: rws.timestamp,
+	})
+}
+
+func formatFloat(f float64) string {
+	return strconv.FormatFloat(f, 'g', -1, 64)
+}
+
+// EncodeRemoteWriteRequest encodes time-series as protobuf WriteRequest
+// message of Prometheus remote-write protocol (v1), prior to compression.
+func EncodeRemoteWriteRequest(series []RemoteWriteSeries) []byte {
+	// message WriteRequest { repeated TimeSeries timeseries = 1; }
+	// message TimeSeries { repeated Label labels = 1; repeated Sample samples = 2; }
+	// message Label { string name = 1; string value = 2; }
+	// message Sample { double value = 1; int64 timestamp = 2; }
+	var req, ts, msg []byte
+	for _, s := range series {
+		ts = ts[:0]
+		for _, label := range s.Labels {
+			msg = msg[:0]
+			msg = protowire.AppendTag(msg, 1, protowire.BytesType)
+			msg = protowire.AppendString(msg, label.Name)
+			msg = protowire.AppendTag(msg, 2, protowire.BytesType)
+			msg = protowire.AppendString(msg, label.Value)
+			ts = protowire.AppendTag(ts, 1, protowire.BytesType)
+			ts = protowire.AppendBytes(ts, msg)
+		}
+		msg = msg[:0]
+		msg = protowire.AppendTag(msg, 1, protowire.Fixed64Type)
+		msg = protowire.AppendFixed64(msg, math.Float64bits(s.Value))
+		msg = protowire.AppendTag(msg, 2, protowire.VarintType)
+		msg = protowire.AppendVarint(msg, uint64(s.Timestamp))
+		ts = protowire.AppendTag(ts, 2, protowire.BytesType)
+		ts = protowire.AppendBytes(ts, msg)
+		req = protowire.AppendTag(req, 1, protowire.BytesType)
+		req = protowire.AppendBytes(req, ts)
+	}
+	return req
+}
+
+func (sme *smbMetricsExporter) newRemoteWritePusher() metricsPusher {
+	labels := pushGroupingLabels()
+	labels["job"] = DefaultPushJob
+	return &remoteWritePusher{
+		url:      sme.cfg.RemoteWriteURL,
+		client:   sme.pushHTTPClient(),
+		gatherer: sme.gatherer(),
+		labels:   labels,
+	}
+}
+
+type remoteWritePusher struct {
+	url      string
+	client   *http.Client
+	gatherer prometheus.Gatherer
+	labels   map[string]string
+}
+
+func (rp *remoteWritePusher) push(ctx context.Context) error {
+	mfs, err := rp.gatherer.Gather()
+	if err != nil {
+		return err
+	}
+	series := MakeRemoteWriteSeries(mfs, rp.labels, time.Now())
+	body := s2.EncodeSnappy(nil, EncodeRemoteWriteRequest(series))
+	req, err := http.NewRequestWithContext(
+		ctx, http.MethodPost, rp.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Encoding", "snappy")
+	req.Header.Set("Content-Type", "application/x-protobuf")
+	req.Header.Set("User-Agent", DefaultPushJob)
+	req.Header.Set("X-Prometheus-Remote-Write-Version", "0.1.0")
+	resp, err := rp.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("remote-write to %s failed: %s: %s",
+			rp.url, resp.Status, strings.TrimSpace(string(msg)))
+	}
+	_, _ = io.Copy(io.Discard, resp.Body)
+	return nil
+}
@@ -0,0 +1,79 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package metrics
+
+import (
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+// smbInfoScrape holds the SMB status information which is fetched (at most
+// once) on behalf of all collectors within a single gathering of metrics
+type smbInfoScrape struct {
+	once    sync.Once
+	smbInfo *SMBInfo
+	err     error
+}
+
+// scrapeState tracks in-flight gatherings of metrics; overlapping gatherings
+// (e.g., by multiple scrapers) share the same SMB status information
+type scrapeState struct {
+	mutex  sync.Mutex
+	active int
+	cur    *smbInfoScrape
+}
+
+// scrapeGatherer gathers exporter's registry within a scrape, so that
+// collectors do not execute the same smbstatus commands over and over
+type scrapeGatherer struct {
+	sme *smbMetricsExporter
+}
+
+func (sg *scrapeGatherer) Gather() ([]*dto.MetricFamily, error) {
+	sg.sme.beginScrape()
+	defer sg.sme.endScrape()
+	return sg.sme.reg.Gather()
+}
+
+// gatherer returns the gatherer via which all metrics of exporter should be
+// collected
+func (sme *smbMetricsExporter) gatherer() prometheus.Gatherer {
+	return &scrapeGatherer{sme: sme}
+}
+
+func (sme *smbMetricsExporter) beginScrape() {
+	sme.scrape.mutex.Lock()
+	defer sme.scrape.mutex.Unlock()
+	if sme.scrape.active == 0 {
+		sme.scrape.cur = &smbInfoScrape{}
+	}
+	sme.scrape.active++
+}
+
+func (sme *smbMetricsExporter) endScrape() {
+	sme.scrape.mutex.Lock()
+	defer sme.scrape.mutex.Unlock()
+	sme.scrape.active--
+	if sme.scrape.active == 0 {
+		sme.scrape.cur = nil
+	}
+}
+
+// sharedSMBInfo returns SMB status information which is shared by all
+// collectors of current scrape, or fetches it anew when collected outside of
+// a scrape. Each caller gets its own copy, which it may further update or
+// restrict.
+func (sme *smbMetricsExporter) sharedSMBInfo() (*SMBInfo, error) {
+	sme.scrape.mutex.Lock()
+	cur := sme.scrape.cur
+	sme.scrape.mutex.Unlock()
+	if cur == nil {
+		return NewUpdatedSMBInfo(sme.ctx, sme.log)
+	}
+	cur.once.Do(func() {
+		cur.smbInfo, cur.err = NewUpdatedSMBInfo(sme.ctx, sme.log)
+	})
+	return cur.smbInfo.clone(), cur.err
+}
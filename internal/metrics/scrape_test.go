@@ -0,0 +1,36 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package metrics
+
+import (
+	"testing"
+
+	"github.com/go-logr/logr"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSharedSMBInfo(t *testing.T) {
+	sme := newSmbMetricsExporter(t.Context(), logr.Discard(), &ExporterConfig{})
+	sme.beginScrape()
+	sme.beginScrape()
+	cur := sme.scrape.cur
+	// Pretend smbstatus has already run within this scrape
+	cur.once.Do(func() {
+		cur.smbInfo = newTestSMBInfo(t, "smbstatus-openfiles.json")
+	})
+
+	smbInfo1, err := sme.sharedSMBInfo()
+	assert.NoError(t, err)
+	smbInfo2, err := sme.sharedSMBInfo()
+	assert.NoError(t, err)
+	assert.NotSame(t, smbInfo1, smbInfo2)
+	smbInfo1.RestrictToVNN("1")
+	assert.Equal(t, smbInfo1.TotalSessions(), 0)
+	assert.Equal(t, smbInfo2.TotalSessions(), 2)
+
+	// Overlapping scrapes share the same information until the last ends
+	sme.endScrape()
+	assert.Same(t, sme.scrape.cur, cur)
+	sme.endScrape()
+	assert.Nil(t, sme.scrape.cur)
+}
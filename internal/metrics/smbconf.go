@@ -0,0 +1,290 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package metrics
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// DefaultSMBConfCacheTTL is the time for which the parsed configuration of
+// shares is reused across scrapes, before running testparm again
+var DefaultSMBConfCacheTTL = time.Minute
+
+// SMBShareConfig represents a single share section of Samba configuration
+type SMBShareConfig struct {
+	Name string
+	// Params maps normalized parameter names (lower-case, without spaces
+	// or underscores) to their values
+	Params map[string]string
+}
+
+// Param returns the value of parameter name, or empty string if not set
+func (sc *SMBShareConfig) Param(name string) string {
+	return sc.Params[normalizeSMBConfParam(name)]
+}
+
+// Path returns the share's root directory
+func (sc *SMBShareConfig) Path() string {
+	return sc.Param("path")
+}
+
+// ReadOnly returns true if share does not allow write access (Samba
+// default)
+func (sc *SMBShareConfig) ReadOnly() bool {
+	if val, ok := sc.lookupBool("read only"); ok {
+		return val
+	}
+	for _, synonym := range []string{"writeable", "writable", "write ok"} {
+		if val, ok := sc.lookupBool(synonym); ok {
+			return !val
+		}
+	}
+	return true
+}
+
+// Browseable returns true if share is visible in share listing (Samba
+// default)
+func (sc *SMBShareConfig) Browseable() bool {
+	for _, synonym := range []string{"browseable", "browsable"} {
+		if val, ok := sc.lookupBool(synonym); ok {
+			return val
+		}
+	}
+	return true
+}
+
+// GuestOK returns true if share allows guest access
+func (sc *SMBShareConfig) GuestOK() bool {
+	for _, synonym := range []string{"guest ok", "public"} {
+		if val, ok := sc.lookupBool(synonym); ok {
+			return val
+		}
+	}
+	return false
+}
+
+// VFSObjects returns the share's (space separated) list of VFS modules
+func (sc *SMBShareConfig) VFSObjects() string {
+	return strings.Join(strings.Fields(sc.Param("vfs objects")), " ")
+}
+
+func (sc *SMBShareConfig) lookupBool(name string) (bool, bool) {
+	val, found := sc.Params[normalizeSMBConfParam(name)]
+	if !found {
+		return false, false
+	}
+	switch strings.ToLower(val) {
+	case "yes", "true", "on", "1":
+		return true, true
+	case "no", "false", "off", "0":
+		return false, true
+	}
+	return false, false
+}
+
+func normalizeSMBConfParam(name string) string {
+	name = strings.ReplaceAll(name, "_", " ")
+	return strings.ToLower(strings.Join(strings.Fields(name), ""))
+}
+
+// RunSMBConfShares returns the configured shares, using 'testparm -s' when
+// available (which covers both file and registry configuration) or else
+// 'net conf list' (registry configuration only). Newer versions of testparm
+// output JSON (with '--json'), which preserves values exactly as configured;
+// older versions fail with unknown option, in which case smb.conf format is
+// used instead.
+func RunSMBConfShares(ctx context.Context) ([]SMBShareConfig, error) {
+	var dat string
+	loc, err := locateExecutable("testparm", "/usr/bin/testparm")
+	if err == nil {
+		dat, err = executeCommand(ctx, loc, "-s", "--json")
+		if err != nil && ctx.Err() == nil {
+			dat, err = executeCommand(ctx, loc, "-s")
+		}
+	} else {
+		dat, err = executeCommand(ctx, "net", "conf", "list")
+	}
+	if err != nil {
+		return []SMBShareConfig{}, err
+	}
+	return parseSMBConfShares(dat)
+}
+
+// parseSMBConfShares parses Samba configuration in either smb.conf (ini)
+// format or JSON format (an object which maps section names to objects of
+// parameters) and returns its share sections sorted by name.
+func parseSMBConfShares(data string) ([]SMBShareConfig, error) {
+	var sections map[string]map[string]string
+	var err error
+	if strings.HasPrefix(strings.TrimSpace(data), "{") {
+		sections, err = parseSMBConfJSON(data)
+	} else {
+		sections, err = parseSMBConfINI(data)
+	}
+	shares := []SMBShareConfig{}
+	if err != nil {
+		return shares, err
+	}
+	for name, params := range sections {
+		if strings.EqualFold(name, "global") {
+			continue
+		}
+		shares = append(shares, SMBShareConfig{Name: name, Params: params})
+	}
+	sort.Slice(shares, func(i, j int) bool {
+		return shares[i].Name < shares[j].Name
+	})
+	return shares, nil
+}
+
+func parseSMBConfINI(data string) (map[string]map[string]string, error) {
+	sections := map[string]map[string]string{}
+	var params map[string]string
+	scanner := bufio.NewScanner(strings.NewReader(data))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || line[0] == '#' || line[0] == ';' {
+			continue
+		}
+		if line[0] == '[' && line[len(line)-1] == ']' {
+			name := strings.TrimSpace(line[1 : len(line)-1])
+			params = map[string]string{}
+			sections[name] = params
+			continue
+		}
+		key, val, found := strings.Cut(line, "=")
+		if !found || params == nil {
+			return sections, fmt.Errorf("malformed smb.conf line: %q", line)
+		}
+		params[normalizeSMBConfParam(key)] = strings.TrimSpace(val)
+	}
+	return sections, scanner.Err()
+}
+
+func parseSMBConfJSON(data string) (map[string]map[string]string, error) {
+	sections := map[string]map[string]string{}
+	raw := map[string]map[string]any{}
+	if err := json.Unmarshal([]byte(data), &raw); err != nil {
+		return sections, err
+	}
+	for name, rawParams := range raw {
+		params := map[string]string{}
+		for key, val := range rawParams {
+			params[normalizeSMBConfParam(key)] = fmt.Sprint(val)
+		}
+		sections[name] = params
+	}
+	return sections, nil
+}
+
+// smbConfCache keeps the most recently parsed configuration of shares, which
+// is used by multiple collectors and rarely changes
+type smbConfCache struct {
+	mutex   sync.Mutex
+	shares  []SMBShareConfig
+	updated time.Time
+}
+
+// smbConfShares returns the configured shares, re-reading the configuration
+// only when the cached one is older than DefaultSMBConfCacheTTL. The returned
+// shares are shared by all callers and must not be modified.
+func (sme *smbMetricsExporter) smbConfShares() ([]SMBShareConfig, error) {
+	cache := &sme.smbConf
+	cache.mutex.Lock()
+	defer cache.mutex.Unlock()
+	if !cache.updated.IsZero() && time.Since(cache.updated) < DefaultSMBConfCacheTTL {
+		return cache.shares, nil
+	}
+	shares, err := RunSMBConfShares(sme.ctx)
+	if err != nil {
+		return shares, err
+	}
+	cache.shares, cache.updated = shares, time.Now()
+	return shares, nil
+}
+
+type smbShareConfigCollector struct {
+	smbCollector
+}
+
+func (col *smbShareConfigCollector) Collect(ch chan<- prometheus.Metric) {
+	shares, err := col.sme.smbConfShares()
+	if err != nil {
+		col.sme.log.Error(err, "failed to resolve shares configuration")
+		return
+	}
+	for i := range shares {
+		share := &shares[i]
+		ch <- prometheus.MustNewConstMetric(col.dsc[0],
+			prometheus.GaugeValue, 1,
+			share.Name,
+			share.Path(),
+			boolToLabel(share.ReadOnly()),
+			boolToLabel(share.Browseable()),
+			boolToLabel(share.GuestOK()),
+			share.VFSObjects())
+	}
+	ch <- prometheus.MustNewConstMetric(col.dsc[1],
+		prometheus.GaugeValue, float64(len(shares)))
+
+	smbInfo, err := col.sme.sharedSMBInfo()
+	if err != nil {
+		return
+	}
+	// Share names are case-insensitive
+	inUse := map[string]bool{}
+	for service := range smbInfo.MapServiceToMachines() {
+		inUse[strings.ToLower(service)] = true
+	}
+	active := 0
+	for _, share := range shares {
+		if inUse[strings.ToLower(share.Name)] {
+			active++
+		}
+	}
+	ch <- prometheus.MustNewConstMetric(col.dsc[2],
+		prometheus.GaugeValue, float64(active))
+}
+
+func (sme *smbMetricsExporter) newSMBShareConfigCollector() prometheus.Collector {
+	col := &smbShareConfigCollector{}
+	col.sme = sme
+	col.dsc = []*prometheus.Desc{
+		prometheus.NewDesc(
+			collectorName("share", "info"),
+			"Configuration of defined SMB share",
+			[]string{
+				"share",
+				"path",
+				"read_only",
+				"browseable",
+				"guest_ok",
+				"vfs_objects",
+			}, nil),
+		prometheus.NewDesc(
+			collectorName("shares", "configured"),
+			"Number of configured SMB shares",
+			[]string{}, nil),
+		prometheus.NewDesc(
+			collectorName("shares", "active"),
+			"Number of configured SMB shares currently in use",
+			[]string{}, nil),
+	}
+	return col
+}
+
+func boolToLabel(b bool) string {
+	if b {
+		return "yes"
+	}
+	return "no"
+}
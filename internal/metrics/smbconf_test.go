@@ -0,0 +1,69 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package metrics
+
+import (
+	"testing"
+	"time"
+
+	"github.com/go-logr/logr"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseSMBConfShares(t *testing.T) {
+	testdata := readTestData(t, "testparm-s.txt")
+	shares, err := parseSMBConfShares(testdata)
+	assert.NoError(t, err)
+	assert.Equal(t, len(shares), 4)
+
+	archive := shares[0]
+	assert.Equal(t, archive.Name, "Data:Archive")
+	assert.Equal(t, archive.Path(), "/mnt/archive")
+	assert.False(t, archive.ReadOnly())
+	assert.False(t, archive.Browseable())
+
+	homes := shares[1]
+	assert.Equal(t, homes.Name, "homes")
+	assert.Equal(t, homes.Path(), "")
+	assert.False(t, homes.ReadOnly())
+	assert.False(t, homes.Browseable())
+
+	share1 := shares[2]
+	assert.Equal(t, share1.Name, "smbshare1")
+	assert.Equal(t, share1.Path(), "/mnt/share1")
+	assert.False(t, share1.ReadOnly())
+	assert.True(t, share1.Browseable())
+	assert.False(t, share1.GuestOK())
+	assert.Equal(t, share1.VFSObjects(), "acl_xattr fruit streams_xattr")
+
+	share2 := shares[3]
+	assert.True(t, share2.ReadOnly())
+	assert.True(t, share2.GuestOK())
+	assert.Equal(t, share2.VFSObjects(), "")
+
+	_, err = parseSMBConfShares("path = /mnt/share1\n")
+	assert.Error(t, err)
+}
+
+func TestParseSMBConfSharesJSON(t *testing.T) {
+	shares, err := parseSMBConfShares(`{
+		"global": {"netbios name": "SMB-TEST"},
+		"share": {"path": "/mnt/share", "read only": false, "vfs_objects": "fruit"}
+	}`)
+	assert.NoError(t, err)
+	assert.Equal(t, len(shares), 1)
+	assert.Equal(t, shares[0].Name, "share")
+	assert.Equal(t, shares[0].Path(), "/mnt/share")
+	assert.False(t, shares[0].ReadOnly())
+	assert.Equal(t, shares[0].VFSObjects(), "fruit")
+}
+
+func TestSMBConfSharesCached(t *testing.T) {
+	sme := newSmbMetricsExporter(t.Context(), logr.Discard(), &ExporterConfig{})
+	cached := []SMBShareConfig{{Name: "share", Params: map[string]string{}}}
+	sme.smbConf.shares = cached
+	sme.smbConf.updated = time.Now()
+	shares, err := sme.smbConfShares()
+	assert.NoError(t, err)
+	assert.Equal(t, cached, shares)
+}
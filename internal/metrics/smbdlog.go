@@ -0,0 +1,216 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package metrics
+
+import (
+	"context"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	smbdLogHeaderRegexp   = regexp.MustCompile(`\[\d{4}/\d{2}/\d{2} [^,\]]*,\s*(\d+)[^\]]*\]`)
+	smbdLogLocationRegexp = regexp.MustCompile(`^\S+:\d+\(\w*\)$`)
+	smbdLogNTStatusRegexp = regexp.MustCompile(`NT_STATUS_[A-Z0-9_]+`)
+	// smbdLogPanicRegexp matches the message which smb_panic() logs, either
+	// 'PANIC (pid <pid>): <why>' or (by older Samba versions) 'PANIC: <why>'
+	smbdLogPanicRegexp = regexp.MustCompile(`(?:^|\s)PANIC(?: \(pid \d+\))?: `)
+
+	// smbdLogErrorPatterns maps known error conditions to (lower-case)
+	// sub-strings of smbd log messages which indicate them
+	smbdLogErrorPatterns = map[string][]string{
+		"connection_reset": {"connection reset"},
+		"dc_not_found": {
+			"failed to find domain controller",
+			"failed to find dc",
+		},
+	}
+)
+
+// SMBDLogLine represents the classification of a single smbd log line
+type SMBDLogLine struct {
+	// Header is true for debug header lines, which carry the debug level of
+	// the following message lines
+	Header bool
+	// Level is the debug level of a header line
+	Level int
+	// NTStatus holds the NT_STATUS codes mentioned in line
+	NTStatus []string
+	// Errors holds the names of known error patterns which match line
+	Errors []string
+}
+
+// ParseSMBDLogLine classifies a line of smbd log file. Both Samba's own log
+// format and (exported) journald or syslog lines which embed it are
+// supported.
+func ParseSMBDLogLine(line string) *SMBDLogLine {
+	ret := &SMBDLogLine{}
+	if match := smbdLogHeaderRegexp.FindStringSubmatch(line); match != nil {
+		ret.Header = true
+		ret.Level, _ = strconv.Atoi(match[1])
+		// The header is followed either by source location (message is on
+		// following lines) or, with 'debug prefix timestamp', by message
+		_, line, _ = strings.Cut(line, match[0])
+		line = strings.TrimSpace(line)
+		if smbdLogLocationRegexp.MatchString(line) {
+			line = ""
+		}
+	}
+	ret.NTStatus = smbdLogNTStatusRegexp.FindAllString(line, -1)
+	lower := strings.ToLower(line)
+	for name, patterns := range smbdLogErrorPatterns {
+		for _, pattern := range patterns {
+			if strings.Contains(lower, pattern) {
+				ret.Errors = append(ret.Errors, name)
+				break
+			}
+		}
+	}
+	if smbdLogPanicRegexp.MatchString(line) {
+		ret.Errors = append(ret.Errors, "panic")
+	}
+	sort.Strings(ret.Errors)
+	return ret
+}
+
+type smbdLogCollector struct {
+	sme      *smbMetricsExporter
+	messages *prometheus.CounterVec
+	ntStatus *prometheus.CounterVec
+	errors   *prometheus.CounterVec
+	mutex    sync.Mutex
+	// levels tracks the debug level of last header line per followed file
+	levels map[string]int
+}
+
+func (col *smbdLogCollector) Describe(ch chan<- *prometheus.Desc) {
+	col.messages.Describe(ch)
+	col.ntStatus.Describe(ch)
+	col.errors.Describe(ch)
+}
+
+func (col *smbdLogCollector) Collect(ch chan<- prometheus.Metric) {
+	col.messages.Collect(ch)
+	col.ntStatus.Collect(ch)
+	col.errors.Collect(ch)
+}
+
+// follow tails all files which match the configured smbd log paths. Paths
+// may be glob patterns (e.g. '/var/log/samba/log.*') which are re-evaluated
+// periodically, so that per-client log files are picked up as they are
+// created, and no longer followed once they are removed.
+func (col *smbdLogCollector) follow(ctx context.Context) {
+	followed := map[string]context.CancelFunc{}
+	fromStart := col.sme.cfg.TailFromStart
+	ticker := time.NewTicker(DefaultTailInterval)
+	defer ticker.Stop()
+	for {
+		col.refollow(ctx, followed, fromStart)
+		// Files which show up later on are new and read from start
+		fromStart = true
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// refollow starts tailing newly matched paths, and stops tailing (and
+// forgets the state of) followed paths which no longer match
+func (col *smbdLogCollector) refollow(ctx context.Context,
+	followed map[string]context.CancelFunc, fromStart bool) {
+	paths := map[string]bool{}
+	for _, path := range col.resolvePaths() {
+		paths[path] = true
+		if followed[path] != nil {
+			continue
+		}
+		tailCtx, cancel := context.WithCancel(ctx)
+		followed[path] = cancel
+		tailer := newFileTailer(path, fromStart, col.sme.log)
+		go tailer.run(tailCtx, func(line string) {
+			col.handleLine(path, line)
+		})
+	}
+	for path, cancel := range followed {
+		if paths[path] {
+			continue
+		}
+		cancel()
+		delete(followed, path)
+		col.mutex.Lock()
+		delete(col.levels, path)
+		col.mutex.Unlock()
+	}
+}
+
+// resolvePaths expands the configured smbd log paths. Patterns without glob
+// meta-characters are literal paths, which are followed even if they do not
+// exist (yet).
+func (col *smbdLogCollector) resolvePaths() []string {
+	paths := []string{}
+	for _, pattern := range col.sme.cfg.SmbdLogs {
+		if !strings.ContainsAny(pattern, `*?[\`) {
+			paths = append(paths, pattern)
+			continue
+		}
+		matches, err := filepath.Glob(pattern)
+		if err != nil {
+			col.sme.log.V(1).Info("malformed smbd log pattern",
+				"pattern", pattern, "err", err)
+			continue
+		}
+		for _, match := range matches {
+			// Skip files rotated by smbd itself
+			if !strings.HasSuffix(match, ".old") {
+				paths = append(paths, match)
+			}
+		}
+	}
+	return paths
+}
+
+func (col *smbdLogCollector) handleLine(path, line string) {
+	parsed := ParseSMBDLogLine(line)
+	col.mutex.Lock()
+	if parsed.Header {
+		col.levels[path] = parsed.Level
+	}
+	level := strconv.Itoa(col.levels[path])
+	col.mutex.Unlock()
+
+	if parsed.Header {
+		col.messages.WithLabelValues(level).Inc()
+	}
+	for _, status := range parsed.NTStatus {
+		col.ntStatus.WithLabelValues(status, level).Inc()
+	}
+	for _, name := range parsed.Errors {
+		col.errors.WithLabelValues(name, level).Inc()
+	}
+}
+
+func (sme *smbMetricsExporter) newSMBDLogCollector() *smbdLogCollector {
+	col := &smbdLogCollector{sme: sme, levels: map[string]int{}}
+	col.messages = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: collectorName("smbd_log", "messages_total"),
+		Help: "Number of smbd log messages by debug level",
+	}, []string{"level"})
+	col.ntStatus = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: collectorName("smbd_log", "nt_status_total"),
+		Help: "Number of NT_STATUS codes reported in smbd log",
+	}, []string{"status", "level"})
+	col.errors = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: collectorName("smbd_log", "errors_total"),
+		Help: "Number of known error conditions reported in smbd log",
+	}, []string{"pattern", "level"})
+	return col
+}
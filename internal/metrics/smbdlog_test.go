@@ -0,0 +1,111 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package metrics
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/go-logr/logr"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseSMBDLogLine(t *testing.T) {
+	line := ParseSMBDLogLine(
+		"[2024/03/11 10:15:02.123456,  0] ../../source3/smbd/server.c:1741(main)")
+	assert.True(t, line.Header)
+	assert.Equal(t, line.Level, 0)
+	assert.Empty(t, line.NTStatus)
+
+	line = ParseSMBDLogLine(
+		"[2024/03/11 10:20:12.000001,  3, pid=1337] status[NT_STATUS_ACCESS_DENIED]")
+	assert.True(t, line.Header)
+	assert.Equal(t, line.Level, 3)
+	assert.Equal(t, line.NTStatus, []string{"NT_STATUS_ACCESS_DENIED"})
+
+	line = ParseSMBDLogLine(
+		"  get_dc_list: failed to find domain controller for SAMDOM: " +
+			"NT_STATUS_NO_LOGON_SERVERS")
+	assert.False(t, line.Header)
+	assert.Equal(t, line.NTStatus, []string{"NT_STATUS_NO_LOGON_SERVERS"})
+	assert.Equal(t, line.Errors, []string{"dc_not_found"})
+
+	for _, msg := range []string{
+		"  PANIC (pid 1337): internal error in 4.21.0",
+		"  PANIC: assert failed",
+		"[2024/03/11 10:21:00.102030,  0, pid=1337] PANIC (pid 1337): internal error",
+		"Mar 11 10:21:00 smb-0 smbd[1337]:   PANIC (pid 1337): internal error",
+	} {
+		line = ParseSMBDLogLine(msg)
+		assert.Equal(t, line.Errors, []string{"panic"}, msg)
+	}
+	for _, msg := range []string{
+		"[2024/03/11 10:21:00.102030,  0] ../../source3/lib/util.c:703(smb_panic_s3)",
+		"  smb_panic(): calling panic action [/bin/sleep 999999]",
+		"  panic action = /bin/sleep 999999",
+		"  create_file: PANICKED_FILE.txt",
+	} {
+		line = ParseSMBDLogLine(msg)
+		assert.Empty(t, line.Errors, msg)
+	}
+}
+
+func TestSMBDLogCollector(t *testing.T) {
+	sme := newSmbMetricsExporter(t.Context(), logr.Discard(), &ExporterConfig{})
+	col := sme.newSMBDLogCollector()
+	for _, line := range strings.Split(readTestData(t, "log.smbd"), "\n") {
+		col.handleLine("log.smbd", line)
+	}
+	assert.Equal(t, testutil.ToFloat64(col.messages.WithLabelValues("0")),
+		float64(4))
+	assert.Equal(t, testutil.ToFloat64(col.messages.WithLabelValues("1")),
+		float64(1))
+	assert.Equal(t, testutil.ToFloat64(
+		col.ntStatus.WithLabelValues("NT_STATUS_ACCESS_DENIED", "1")),
+		float64(1))
+	assert.Equal(t, testutil.ToFloat64(
+		col.ntStatus.WithLabelValues("NT_STATUS_ACCESS_DENIED", "3")),
+		float64(1))
+	assert.Equal(t, testutil.ToFloat64(
+		col.errors.WithLabelValues("connection_reset", "0")), float64(1))
+	assert.Equal(t, testutil.ToFloat64(
+		col.errors.WithLabelValues("dc_not_found", "0")), float64(1))
+	assert.Equal(t, testutil.ToFloat64(
+		col.errors.WithLabelValues("panic", "0")), float64(1))
+}
+
+func TestSMBDLogCollectorRefollow(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{"log.smbd", "log.client1", "log.client1.old"} {
+		err := os.WriteFile(filepath.Join(dir, name), []byte{}, 0o600)
+		assert.NoError(t, err)
+	}
+	literal := filepath.Join(dir, "log.missing")
+	sme := newSmbMetricsExporter(t.Context(), logr.Discard(), &ExporterConfig{
+		SmbdLogs: []string{filepath.Join(dir, "log.*"), literal},
+	})
+	col := sme.newSMBDLogCollector()
+	assert.ElementsMatch(t, []string{
+		filepath.Join(dir, "log.client1"), filepath.Join(dir, "log.smbd"), literal,
+	}, col.resolvePaths())
+
+	followed := map[string]context.CancelFunc{}
+	col.refollow(t.Context(), followed, false)
+	assert.Len(t, followed, 3)
+	client1 := filepath.Join(dir, "log.client1")
+	col.handleLine(client1, "[2026/01/02 03:04:05.678901,  3] smbd/server.c:1(main)")
+	assert.Contains(t, col.levels, client1)
+
+	assert.NoError(t, os.Remove(client1))
+	col.refollow(t.Context(), followed, true)
+	assert.Len(t, followed, 2)
+	assert.NotContains(t, followed, client1)
+	assert.NotContains(t, col.levels, client1)
+	for _, cancel := range followed {
+		cancel()
+	}
+}
@@ -0,0 +1,89 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package metrics
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/prometheus/procfs"
+)
+
+// ResolveSmbdStartTime returns the pid and start time of main smbd daemon,
+// which is the earliest started smbd process visible in exporter's PID
+// namespace
+func ResolveSmbdStartTime(fs procfs.FS) (int, time.Time, error) {
+	procs, err := fs.AllProcs()
+	if err != nil {
+		return 0, time.Time{}, err
+	}
+	pid := 0
+	earliest := 0.0
+	for _, proc := range procs {
+		startTime, err := smbdProcessStartTime(proc)
+		if err != nil {
+			continue
+		}
+		if earliest == 0 || startTime < earliest {
+			pid, earliest = proc.PID, startTime
+		}
+	}
+	if earliest == 0 {
+		return 0, time.Time{}, errors.New("no smbd process found")
+	}
+	return pid, secondsToTime(earliest), nil
+}
+
+func smbdProcessStartTime(proc procfs.Proc) (float64, error) {
+	comm, err := proc.Comm()
+	if err != nil {
+		return 0, err
+	}
+	if comm != "smbd" {
+		return 0, fmt.Errorf("not an smbd process: %d", proc.PID)
+	}
+	stat, err := proc.Stat()
+	if err != nil {
+		return 0, err
+	}
+	return stat.StartTime()
+}
+
+// smbdStartCache keeps the resolved pid and start time of main smbd daemon,
+// so that /proc is walked only when the daemon has been restarted
+type smbdStartCache struct {
+	mutex     sync.Mutex
+	pid       int
+	startTime time.Time
+}
+
+// smbdStartTime returns the start time of smbd, or zero time if unknown
+func (sme *smbMetricsExporter) smbdStartTime() time.Time {
+	cache := &sme.smbdStart
+	cache.mutex.Lock()
+	defer cache.mutex.Unlock()
+
+	fs, err := procfs.NewDefaultFS()
+	if err != nil {
+		return time.Time{}
+	}
+	if cache.pid != 0 {
+		proc, err := fs.Proc(cache.pid)
+		if err == nil {
+			startTime, err := smbdProcessStartTime(proc)
+			if err == nil && secondsToTime(startTime).Equal(cache.startTime) {
+				return cache.startTime
+			}
+		}
+	}
+	pid, startTime, err := ResolveSmbdStartTime(fs)
+	if err != nil {
+		sme.log.V(1).Info("failed to resolve smbd start time", "err", err)
+		cache.pid, cache.startTime = 0, time.Time{}
+		return time.Time{}
+	}
+	cache.pid, cache.startTime = pid, startTime
+	return startTime
+}
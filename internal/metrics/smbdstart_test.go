@@ -0,0 +1,19 @@
+// SPDX-License-Identifier: Apache-2.0
+
+//go:build linux
+
+package metrics
+
+import (
+	"testing"
+
+	"github.com/prometheus/procfs"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestResolveSmbdStartTime(t *testing.T) {
+	fs, err := procfs.NewFS(t.TempDir())
+	assert.NoError(t, err)
+	_, _, err = ResolveSmbdStartTime(fs)
+	assert.Error(t, err)
+}
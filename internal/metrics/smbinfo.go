@@ -3,18 +3,35 @@
 package metrics
 
 import (
+	"context"
+	"path/filepath"
+	"sort"
+	"strings"
+
 	"github.com/go-logr/logr"
 )
 
+// NonClusterVNN is the VNN reported by smbstatus in non-clustered setup
+const NonClusterVNN = "4294967295"
+
 // SMBInfo provides a bridge layer between raw smbstatus info and exported
 // metric counters. It also implements the more complex logic which requires in
 // memory re-mapping of the low-level information (e.g., stats by machine/user).
 type SMBInfo struct {
 	tconsStatus    *SMBStatus
 	sessionsStatus *SMBStatus
+	openFiles      []SMBStatusOpenFile
 	log            logr.Logger
 }
 
+// SMBInfoFilter selects a subset of SMBInfo entries by share, remote machine
+// and user name. Empty fields match any value.
+type SMBInfoFilter struct {
+	Share   string
+	Machine string
+	User    string
+}
+
 func NewSMBInfo(log logr.Logger) *SMBInfo {
 	return &SMBInfo{
 		tconsStatus:    NewSMBStatus(),
@@ -23,19 +40,26 @@ func NewSMBInfo(log logr.Logger) *SMBInfo {
 	}
 }
 
-func NewUpdatedSMBInfo(log logr.Logger) (*SMBInfo, error) {
+func NewUpdatedSMBInfo(ctx context.Context, log logr.Logger) (*SMBInfo, error) {
 	smbinfo := NewSMBInfo(log)
-	err := smbinfo.Update()
+	err := smbinfo.Update(ctx)
 	return smbinfo, err
 }
 
-func (smbinfo *SMBInfo) Update() error {
-	tconsStatus, err := RunSMBStatusShares()
+// clone returns a shallow copy of smbinfo; updates and restrictions replace
+// (rather than modify) the underlying status, so that copies are independent
+func (smbinfo *SMBInfo) clone() *SMBInfo {
+	ret := *smbinfo
+	return &ret
+}
+
+func (smbinfo *SMBInfo) Update(ctx context.Context) error {
+	tconsStatus, err := RunSMBStatusShares(ctx)
 	if err != nil {
 		smbinfo.log.Error(err, "smbsstatus --shares failed")
 		return err
 	}
-	sessionsStatus, err := RunSMBStatusProcesses()
+	sessionsStatus, err := RunSMBStatusProcesses(ctx)
 	if err != nil {
 		smbinfo.log.Error(err, "smbsstatus --processes failed")
 		return err
@@ -45,6 +69,53 @@ func (smbinfo *SMBInfo) Update() error {
 	return nil
 }
 
+// UpdateOpenFiles fetches current open-files information, which is not part
+// of the default Update
+func (smbinfo *SMBInfo) UpdateOpenFiles(ctx context.Context) error {
+	openFiles, err := RunSMBStatusLocks(ctx)
+	if err != nil {
+		smbinfo.log.Error(err, "smbsstatus --locks failed")
+		return err
+	}
+	smbinfo.openFiles = openFiles
+	return nil
+}
+
+// RestrictToVNN drops all sessions, tree-connections and opens which are not
+// served by the CTDB node vnn
+func (smbinfo *SMBInfo) RestrictToVNN(vnn string) {
+	sessionsStatus := *smbinfo.sessionsStatus
+	sessionsStatus.Sessions = map[string]SMBStatusSession{}
+	for key, session := range smbinfo.sessionsStatus.Sessions {
+		if session.ServerID.VNN == vnn {
+			sessionsStatus.Sessions[key] = session
+		}
+	}
+	tconsStatus := *smbinfo.tconsStatus
+	tconsStatus.TCons = map[string]SMBStatusTreeCon{}
+	for key, tcon := range smbinfo.tconsStatus.TCons {
+		if tcon.ServerID.VNN == vnn {
+			tconsStatus.TCons[key] = tcon
+		}
+	}
+	openFiles := []SMBStatusOpenFile{}
+	for _, openFile := range smbinfo.openFiles {
+		opens := map[string]SMBStatusOpenInfo{}
+		for key, open := range openFile.Opens {
+			if open.ServerID.VNN == vnn {
+				opens[key] = open
+			}
+		}
+		if len(opens) > 0 {
+			openFile.Opens = opens
+			openFiles = append(openFiles, openFile)
+		}
+	}
+	smbinfo.sessionsStatus = &sessionsStatus
+	smbinfo.tconsStatus = &tconsStatus
+	smbinfo.openFiles = openFiles
+}
+
 func (smbinfo *SMBInfo) TotalSessions() int {
 	return len(smbinfo.sessionsStatus.Sessions)
 }
@@ -72,6 +143,45 @@ func (smbinfo *SMBInfo) TotalConnectedUsers() int {
 	return len(users)
 }
 
+func (smbinfo *SMBInfo) TotalOpenFiles() int {
+	return len(smbinfo.openFiles)
+}
+
+func (smbinfo *SMBInfo) MapVNNToSessions() map[string]int {
+	ret := map[string]int{}
+	for _, session := range smbinfo.sessionsStatus.Sessions {
+		ret[session.ServerID.VNN]++
+	}
+	return ret
+}
+
+func (smbinfo *SMBInfo) MapVNNToTreeCons() map[string]int {
+	ret := map[string]int{}
+	for _, tcon := range smbinfo.tconsStatus.TCons {
+		if isInternalServiceID(tcon.Service) {
+			continue
+		}
+		ret[tcon.ServerID.VNN]++
+	}
+	return ret
+}
+
+// MapVNNToOpenFiles counts open files per VNN; a file which is opened via
+// multiple nodes is counted once for each of them.
+func (smbinfo *SMBInfo) MapVNNToOpenFiles() map[string]int {
+	ret := map[string]int{}
+	for _, openFile := range smbinfo.openFiles {
+		vnns := map[string]bool{}
+		for _, open := range openFile.Opens {
+			vnns[open.ServerID.VNN] = true
+		}
+		for vnn := range vnns {
+			ret[vnn]++
+		}
+	}
+	return ret
+}
+
 func (smbinfo *SMBInfo) MapMachineToSessions() map[string][]*SMBStatusSession {
 	ret := map[string][]*SMBStatusSession{}
 	for _, session := range smbinfo.sessionsStatus.Sessions {
@@ -109,6 +219,78 @@ func (smbinfo *SMBInfo) MapMachineToTreeCons() map[string][]*SMBStatusTreeCon {
 	return ret
 }
 
+// MapPIDToMachine maps the pid of each smbd process which serves sessions
+// or tree-connections to the remote machine it serves
+func (smbinfo *SMBInfo) MapPIDToMachine() map[string]string {
+	ret := map[string]string{}
+	for _, session := range smbinfo.sessionsStatus.Sessions {
+		ret[session.ServerID.PID] = session.RemoteMachine
+	}
+	for _, tcon := range smbinfo.tconsStatus.TCons {
+		if _, found := ret[tcon.ServerID.PID]; !found {
+			ret[tcon.ServerID.PID] = tcon.Machine
+		}
+	}
+	return ret
+}
+
+// MapShareClientToOpenFiles counts the opens of each pair of share and
+// remote machine. As open entries carry no share name, each open is
+// attributed to the tree-connected share of its smbd process; when a process
+// has tree-connections to multiple shares, the share whose path (as given by
+// sharePaths, keyed by lower-case share name) equals the open's service path
+// is selected. Opens which can not be attributed to a single share are not
+// counted.
+func (smbinfo *SMBInfo) MapShareClientToOpenFiles(
+	sharePaths map[string]string) map[SMBProfileShareClient]int {
+	pidToServices := map[string]map[string]bool{}
+	for _, tcon := range smbinfo.tconsStatus.TCons {
+		if isInternalServiceID(tcon.Service) {
+			continue
+		}
+		pid := tcon.ServerID.PID
+		if pidToServices[pid] == nil {
+			pidToServices[pid] = map[string]bool{}
+		}
+		pidToServices[pid][tcon.Service] = true
+	}
+	pidToMachine := smbinfo.MapPIDToMachine()
+	ret := map[SMBProfileShareClient]int{}
+	for _, openFile := range smbinfo.openFiles {
+		for _, open := range openFile.Opens {
+			pid := open.ServerID.PID
+			service, found := resolveOpenService(
+				pidToServices[pid], sharePaths, openFile.ServicePath)
+			if !found {
+				continue
+			}
+			ret[SMBProfileShareClient{
+				Share:  service,
+				Client: pidToMachine[pid],
+			}]++
+		}
+	}
+	return ret
+}
+
+func resolveOpenService(services map[string]bool,
+	sharePaths map[string]string, servicePath string) (string, bool) {
+	matched := []string{}
+	for service := range services {
+		if len(services) == 1 {
+			return service, true
+		}
+		path, found := sharePaths[strings.ToLower(service)]
+		if found && filepath.Clean(path) == filepath.Clean(servicePath) {
+			matched = append(matched, service)
+		}
+	}
+	if len(matched) != 1 {
+		return "", false
+	}
+	return matched[0], true
+}
+
 func (smbinfo *SMBInfo) MapServiceToMachines() map[string]map[string]int {
 	ret := map[string]map[string]int{}
 	for _, tcon := range smbinfo.tconsStatus.TCons {
@@ -145,6 +327,122 @@ func (smbinfo *SMBInfo) MapMachineToServies() map[string]map[string]int {
 	return ret
 }
 
+// FilterSessions returns the sessions which match filter, sorted by session
+// id. A session matches share filter if it has a tree-connection to it.
+func (smbinfo *SMBInfo) FilterSessions(filter *SMBInfoFilter) []SMBStatusSession {
+	ret := []SMBStatusSession{}
+	for _, session := range smbinfo.sessionsStatus.Sessions {
+		if !matchFilter(filter.Machine, session.RemoteMachine) ||
+			!matchFilter(filter.User, session.Username) ||
+			!smbinfo.sessionHasService(session.SessionID, filter.Share) {
+			continue
+		}
+		ret = append(ret, session)
+	}
+	sort.Slice(ret, func(i, j int) bool {
+		return ret[i].SessionID < ret[j].SessionID
+	})
+	return ret
+}
+
+// FilterTreeCons returns the (non-internal) tree-connections which match
+// filter, sorted by tree-connection id.
+func (smbinfo *SMBInfo) FilterTreeCons(filter *SMBInfoFilter) []SMBStatusTreeCon {
+	ret := []SMBStatusTreeCon{}
+	for _, tcon := range smbinfo.tconsStatus.TCons {
+		if isInternalServiceID(tcon.Service) ||
+			!matchFilter(filter.Share, tcon.Service) ||
+			!matchFilter(filter.Machine, tcon.Machine) ||
+			!matchFilter(filter.User, smbinfo.sessionUsername(tcon.SessionID)) {
+			continue
+		}
+		ret = append(ret, tcon)
+	}
+	sort.Slice(ret, func(i, j int) bool {
+		return ret[i].TConID < ret[j].TConID
+	})
+	return ret
+}
+
+// FilterOpenFiles returns the open files which have at least one open
+// matching filter, each with its non-matching opens removed. As open entries
+// carry no share name, an open matches share filter when its smbd process
+// has a tree-connection to that share.
+func (smbinfo *SMBInfo) FilterOpenFiles(filter *SMBInfoFilter) []SMBStatusOpenFile {
+	ret := []SMBStatusOpenFile{}
+	for _, openFile := range smbinfo.openFiles {
+		opens := map[string]SMBStatusOpenInfo{}
+		for key, open := range openFile.Opens {
+			if smbinfo.openMatches(&open, filter) {
+				opens[key] = open
+			}
+		}
+		if len(opens) == 0 {
+			continue
+		}
+		openFile.Opens = opens
+		ret = append(ret, openFile)
+	}
+	sort.Slice(ret, func(i, j int) bool {
+		if ret[i].ServicePath != ret[j].ServicePath {
+			return ret[i].ServicePath < ret[j].ServicePath
+		}
+		return ret[i].Filename < ret[j].Filename
+	})
+	return ret
+}
+
+func (smbinfo *SMBInfo) openMatches(open *SMBStatusOpenInfo, filter *SMBInfoFilter) bool {
+	if filter.Share != "" && !smbinfo.processHasService(open.ServerID.PID, filter.Share) {
+		return false
+	}
+	if filter.Machine == "" && filter.User == "" {
+		return true
+	}
+	for _, session := range smbinfo.sessionsStatus.Sessions {
+		if session.ServerID.PID == open.ServerID.PID &&
+			session.UID == open.UID &&
+			matchFilter(filter.Machine, session.RemoteMachine) &&
+			matchFilter(filter.User, session.Username) {
+			return true
+		}
+	}
+	return false
+}
+
+func (smbinfo *SMBInfo) sessionHasService(sessionID, service string) bool {
+	if service == "" {
+		return true
+	}
+	for _, tcon := range smbinfo.tconsStatus.TCons {
+		if tcon.SessionID == sessionID && tcon.Service == service {
+			return true
+		}
+	}
+	return false
+}
+
+func (smbinfo *SMBInfo) processHasService(pid, service string) bool {
+	for _, tcon := range smbinfo.tconsStatus.TCons {
+		if tcon.ServerID.PID == pid && tcon.Service == service {
+			return true
+		}
+	}
+	return false
+}
+
+func (smbinfo *SMBInfo) sessionUsername(sessionID string) string {
+	session, found := smbinfo.sessionsStatus.Sessions[sessionID]
+	if !found {
+		return ""
+	}
+	return session.Username
+}
+
+func matchFilter(want, value string) bool {
+	return want == "" || want == value
+}
+
 func isInternalServiceID(serviceID string) bool {
 	return serviceID == "IPC$"
 }
@@ -163,14 +461,34 @@ func NewSMBProfileInfo(log logr.Logger) *SMBProfileInfo {
 	}
 }
 
-func NewUpdatedSMBProfileInfo(log logr.Logger) (*SMBProfileInfo, error) {
+func NewUpdatedSMBProfileInfo(ctx context.Context, log logr.Logger) (*SMBProfileInfo, error) {
 	smbProfileInfo := NewSMBProfileInfo(log)
-	err := smbProfileInfo.Update()
+	err := smbProfileInfo.Update(ctx)
 	return smbProfileInfo, err
 }
 
-func (smbProfileInfo *SMBProfileInfo) Update() error {
-	profiuleStatus, err := RunSMBStatusProfile()
+// FilterProfile returns a shallow copy of profile information with its
+// per-share entries restricted to those which match filter's share and
+// machine (client address).
+func (smbProfileInfo *SMBProfileInfo) FilterProfile(filter *SMBInfoFilter) *SMBProfile {
+	profile := *smbProfileInfo.profileStatus
+	if profile.Extended == nil {
+		return &profile
+	}
+	profile.Extended = map[string]*SMBProfileShare{}
+	for key, extended := range smbProfileInfo.profileStatus.Extended {
+		shareName, client := ParseExtendedProfileKey(key)
+		if !matchFilter(filter.Share, shareName) ||
+			!matchFilter(filter.Machine, client) {
+			continue
+		}
+		profile.Extended[key] = extended
+	}
+	return &profile
+}
+
+func (smbProfileInfo *SMBProfileInfo) Update(ctx context.Context) error {
+	profiuleStatus, err := RunSMBStatusProfile(ctx)
 	if err != nil {
 		smbProfileInfo.log.Error(err, "smbsstatus --profile failed")
 		return err
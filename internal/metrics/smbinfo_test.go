@@ -0,0 +1,164 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package metrics
+
+import (
+	"testing"
+
+	"github.com/go-logr/logr"
+	"github.com/stretchr/testify/assert"
+)
+
+func newTestSMBInfo(t *testing.T, filename string) *SMBInfo {
+	testdata := readTestData(t, filename)
+	status, err := parseSMBStatus(testdata)
+	assert.NoError(t, err)
+	openFiles, err := parseSMBStatusLockedFiles(testdata)
+	assert.NoError(t, err)
+	smbinfo := NewSMBInfo(logr.Discard())
+	smbinfo.tconsStatus = status
+	smbinfo.sessionsStatus = status
+	smbinfo.openFiles = openFiles
+	return smbinfo
+}
+
+func TestSMBInfoFilterSessions(t *testing.T) {
+	smbinfo := newTestSMBInfo(t, "smbstatus-openfiles.json")
+	sessions := smbinfo.FilterSessions(&SMBInfoFilter{})
+	assert.Equal(t, len(sessions), 2)
+	sessions = smbinfo.FilterSessions(&SMBInfoFilter{Machine: "192.168.122.83"})
+	assert.Equal(t, len(sessions), 1)
+	assert.Equal(t, sessions[0].ServerID.PID, "34128")
+	sessions = smbinfo.FilterSessions(&SMBInfoFilter{Share: "smbshare", User: "testuser"})
+	assert.Equal(t, len(sessions), 2)
+	sessions = smbinfo.FilterSessions(&SMBInfoFilter{Share: "noshare"})
+	assert.Equal(t, len(sessions), 0)
+}
+
+func TestSMBInfoFilterTreeCons(t *testing.T) {
+	smbinfo := newTestSMBInfo(t, "smbstatus-openfiles.json")
+	tcons := smbinfo.FilterTreeCons(&SMBInfoFilter{})
+	assert.Equal(t, len(tcons), 2)
+	for _, tcon := range tcons {
+		assert.Equal(t, tcon.Service, "smbshare")
+	}
+	tcons = smbinfo.FilterTreeCons(&SMBInfoFilter{Machine: "192.168.122.235"})
+	assert.Equal(t, len(tcons), 1)
+	assert.Equal(t, tcons[0].TConID, "1357200611")
+	tcons = smbinfo.FilterTreeCons(&SMBInfoFilter{User: "nouser"})
+	assert.Equal(t, len(tcons), 0)
+}
+
+func TestSMBInfoFilterOpenFiles(t *testing.T) {
+	smbinfo := newTestSMBInfo(t, "smbstatus-openfiles.json")
+	openFiles := smbinfo.FilterOpenFiles(&SMBInfoFilter{})
+	assert.Equal(t, len(openFiles), 2)
+	assert.Equal(t, openFiles[0].Filename, "A/a")
+	assert.Equal(t, len(openFiles[0].Opens), 2)
+
+	openFiles = smbinfo.FilterOpenFiles(&SMBInfoFilter{Machine: "192.168.122.83"})
+	assert.Equal(t, len(openFiles), 2)
+	for _, openFile := range openFiles {
+		assert.Equal(t, len(openFile.Opens), 1)
+		for _, open := range openFile.Opens {
+			assert.Equal(t, open.ServerID.PID, "34128")
+		}
+	}
+	openFiles = smbinfo.FilterOpenFiles(&SMBInfoFilter{Share: "smbshare", User: "testuser"})
+	assert.Equal(t, len(openFiles), 2)
+	openFiles = smbinfo.FilterOpenFiles(&SMBInfoFilter{Share: "IPC$", Machine: "10.0.0.1"})
+	assert.Equal(t, len(openFiles), 0)
+}
+
+func TestSMBProfileInfoFilterProfile(t *testing.T) {
+	testdata := readTestData(t, "smbstatus-profile-per-share2.json")
+	profile, err := parseSMBProfile(testdata)
+	assert.NoError(t, err)
+	smbProfileInfo := NewSMBProfileInfo(logr.Discard())
+	smbProfileInfo.profileStatus = profile
+
+	filtered := smbProfileInfo.FilterProfile(&SMBInfoFilter{})
+	assert.Equal(t, len(filtered.Extended), 2)
+	filtered = smbProfileInfo.FilterProfile(&SMBInfoFilter{Machine: "192.168.122.25"})
+	assert.Equal(t, len(filtered.Extended), 1)
+	assert.NotNil(t, filtered.SMB2Calls)
+	assert.Equal(t, len(profile.Extended), 2)
+}
+
+func TestSMBInfoVNN(t *testing.T) {
+	smbinfo := newTestSMBInfo(t, "smbstatus-openfiles.json")
+	assert.Equal(t, smbinfo.MapVNNToSessions(), map[string]int{NonClusterVNN: 2})
+	assert.Equal(t, smbinfo.MapVNNToTreeCons(), map[string]int{NonClusterVNN: 2})
+	assert.Equal(t, smbinfo.MapVNNToOpenFiles(), map[string]int{NonClusterVNN: 2})
+
+	// Pretend smbd process 34128 is served by CTDB node 1
+	for key, session := range smbinfo.sessionsStatus.Sessions {
+		if session.ServerID.PID == "34128" {
+			session.ServerID.VNN = "1"
+			smbinfo.sessionsStatus.Sessions[key] = session
+		}
+	}
+	for key, tcon := range smbinfo.tconsStatus.TCons {
+		if tcon.ServerID.PID == "34128" {
+			tcon.ServerID.VNN = "1"
+			smbinfo.tconsStatus.TCons[key] = tcon
+		}
+	}
+	for _, openFile := range smbinfo.openFiles {
+		for key, open := range openFile.Opens {
+			if open.ServerID.PID == "34128" {
+				open.ServerID.VNN = "1"
+				openFile.Opens[key] = open
+			}
+		}
+	}
+	assert.Equal(t, smbinfo.MapVNNToSessions(), map[string]int{NonClusterVNN: 1, "1": 1})
+	assert.Equal(t, smbinfo.MapVNNToTreeCons(), map[string]int{NonClusterVNN: 1, "1": 1})
+	assert.Equal(t, smbinfo.MapVNNToOpenFiles(), map[string]int{NonClusterVNN: 2, "1": 2})
+
+	smbinfo.RestrictToVNN("1")
+	assert.Equal(t, smbinfo.TotalSessions(), 1)
+	assert.Equal(t, smbinfo.TotalTreeCons(), 1)
+	assert.Equal(t, smbinfo.TotalOpenFiles(), 2)
+	for _, openFile := range smbinfo.openFiles {
+		assert.Equal(t, len(openFile.Opens), 1)
+	}
+	smbinfo.RestrictToVNN("2")
+	assert.Equal(t, smbinfo.TotalSessions(), 0)
+	assert.Equal(t, smbinfo.TotalOpenFiles(), 0)
+}
+
+func TestSMBInfoMapPIDToMachine(t *testing.T) {
+	smbinfo := newTestSMBInfo(t, "smbstatus-openfiles.json")
+	pidToMachine := smbinfo.MapPIDToMachine()
+	assert.Equal(t, len(pidToMachine), 2)
+	assert.Equal(t, pidToMachine["34128"], "192.168.122.83")
+}
+
+func TestSMBInfoMapShareClientToOpenFiles(t *testing.T) {
+	smbinfo := newTestSMBInfo(t, "smbstatus-openfiles.json")
+	opens := smbinfo.MapShareClientToOpenFiles(nil)
+	assert.Equal(t, opens, map[SMBProfileShareClient]int{
+		{Share: "smbshare", Client: "192.168.122.83"}:  2,
+		{Share: "smbshare", Client: "192.168.122.235"}: 2,
+	})
+
+	// Same process is tree-connected to another share as well
+	smbinfo.tconsStatus.TCons["othershare"] = SMBStatusTreeCon{
+		Service:  "othershare",
+		ServerID: SMBStatusServerID{PID: "34128"},
+		Machine:  "192.168.122.83",
+	}
+	opens = smbinfo.MapShareClientToOpenFiles(nil)
+	assert.Equal(t, opens, map[SMBProfileShareClient]int{
+		{Share: "smbshare", Client: "192.168.122.235"}: 2,
+	})
+	opens = smbinfo.MapShareClientToOpenFiles(map[string]string{
+		"smbshare":   "/",
+		"othershare": "/mnt/other",
+	})
+	assert.Equal(t, opens, map[SMBProfileShareClient]int{
+		{Share: "smbshare", Client: "192.168.122.83"}:  2,
+		{Share: "smbshare", Client: "192.168.122.235"}: 2,
+	})
+}
@@ -3,10 +3,14 @@
 package metrics
 
 import (
+	"context"
 	"encoding/json"
-	"errors"
+	"fmt"
+	"math"
 	"os"
 	"os/exec"
+	"sort"
+	"strconv"
 	"strings"
 )
 
@@ -79,6 +83,7 @@ type SMBStatusOpenFile struct {
 
 // SMBStatusOpenInfo represents a single entry open_files/opens output field
 type SMBStatusOpenInfo struct {
+	ServerID    SMBStatusServerID       `json:"server_id"`
 	UID         int                     `json:"uid"`
 	ShareFileID string                  `json:"share_file_id"`
 	OpenedAt    string                  `json:"opened_at"`
@@ -173,11 +178,54 @@ type SMBProfileLoop struct {
 	PopSecCtx     SMBProfileEntry `json:"pop_sec_ctx"`
 }
 
+// SMBProfileBucket represents a single latency bucket of profile entry, as
+// reported by Samba builds which support it. Count is the number of calls
+// within this bucket only (not cumulative), and Le is its upper bound in
+// microseconds (+Inf for last bucket).
+type SMBProfileBucket struct {
+	Le    float64 `json:"le"`
+	Count int     `json:"count"`
+}
+
+// UnmarshalJSON decodes a profile bucket whose upper bound may be either a
+// number or a string (such as "+Inf")
+func (bucket *SMBProfileBucket) UnmarshalJSON(data []byte) error {
+	raw := struct {
+		Le    json.RawMessage `json:"le"`
+		Count int             `json:"count"`
+	}{}
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return err
+	}
+	bucket.Count = raw.Count
+	var le string
+	if err := json.Unmarshal(raw.Le, &le); err != nil {
+		return json.Unmarshal(raw.Le, &bucket.Le)
+	}
+	val, err := strconv.ParseFloat(le, 64)
+	if err != nil {
+		return fmt.Errorf("malformed profile bucket bound: %w", err)
+	}
+	bucket.Le = val
+	return nil
+}
+
+// MarshalJSON encodes a profile bucket, representing infinite upper bound as
+// string (which JSON numbers can not represent)
+func (bucket SMBProfileBucket) MarshalJSON() ([]byte, error) {
+	var le any = bucket.Le
+	if math.IsInf(bucket.Le, 0) {
+		le = strconv.FormatFloat(bucket.Le, 'f', -1, 64)
+	}
+	return json.Marshal(map[string]any{"le": le, "count": bucket.Count})
+}
+
 // SMBProfileIOEntry represents async-io profile entry of 'smbstatus --profile'
 type SMBProfileIOEntry struct {
 	SMBProfileEntry
-	Idle  int `json:"idle"`
-	Bytes int `json:"bytes"`
+	Idle    int                `json:"idle"`
+	Bytes   int                `json:"bytes"`
+	Buckets []SMBProfileBucket `json:"buckets,omitempty"`
 }
 
 // SMBProfileSyscalls represents 'System Calls' entries of 'smbstatus --profile'
@@ -229,9 +277,10 @@ type SMBProfileSyscalls struct {
 // SMBStatusProfile represents single call entry of 'smbstatus --profile'
 type SMBProfileCallEntry struct {
 	SMBProfileEntry
-	Idle     int `json:"idle"`
-	Inbytes  int `json:"inbytes"`
-	Outbytes int `json:"outbytes"`
+	Idle     int                `json:"idle"`
+	Inbytes  int                `json:"inbytes"`
+	Outbytes int                `json:"outbytes"`
+	Buckets  []SMBProfileBucket `json:"buckets,omitempty"`
 }
 
 // SMBProfileSMB2Calls represents 'SMB2 Calls' entries of 'smbstatus --profile'
@@ -274,11 +323,87 @@ type SMBProfile struct {
 	Extended    map[string]*SMBProfileShare `json:"Extended Profile"`
 }
 
+// Operations maps SMB2 operation names to their profile entries
+func (smb2Calls *SMBProfileSMB2Calls) Operations() map[string]*SMBProfileCallEntry {
+	return map[string]*SMBProfileCallEntry{
+		"negprot":   &smb2Calls.NegProt,
+		"sesssetup": &smb2Calls.SessSetup,
+		"logoff":    &smb2Calls.LogOff,
+		"tcon":      &smb2Calls.Tcon,
+		"tdis":      &smb2Calls.Tdis,
+		"create":    &smb2Calls.Create,
+		"close":     &smb2Calls.Close,
+		"flush":     &smb2Calls.Flush,
+		"read":      &smb2Calls.Read,
+		"write":     &smb2Calls.Write,
+		"lock":      &smb2Calls.Lock,
+		"ioctl":     &smb2Calls.Ioctl,
+		"cancel":    &smb2Calls.Cancel,
+		"keepalive": &smb2Calls.KeepAlive,
+		"find":      &smb2Calls.Find,
+		"notify":    &smb2Calls.Notify,
+		"getinfo":   &smb2Calls.GetInfo,
+		"setinfo":   &smb2Calls.SetInfo,
+		"break":     &smb2Calls.Break,
+	}
+}
+
+// IOOperations maps names of system calls which transfer data to their
+// profile entries
+func (sysCalls *SMBProfileSyscalls) IOOperations() map[string]*SMBProfileIOEntry {
+	return map[string]*SMBProfileIOEntry{
+		"pread":       &sysCalls.PRead,
+		"asys_pread":  &sysCalls.AsysPRead,
+		"pwrite":      &sysCalls.PWrite,
+		"asys_pwrite": &sysCalls.AsysPWrite,
+		"asys_fsync":  &sysCalls.AsysFSync,
+	}
+}
+
+// Operations maps names of system calls which do not transfer data to their
+// profile entries
+func (sysCalls *SMBProfileSyscalls) Operations() map[string]*SMBProfileEntry {
+	return map[string]*SMBProfileEntry{
+		"opendir":    &sysCalls.Opendir,
+		"fdopendir":  &sysCalls.FDOpendir,
+		"readdir":    &sysCalls.Readdir,
+		"rewinddir":  &sysCalls.Rewinddir,
+		"mkdirat":    &sysCalls.Mkdirat,
+		"closedir":   &sysCalls.Closedir,
+		"open":       &sysCalls.Open,
+		"openat":     &sysCalls.OpenAt,
+		"createfile": &sysCalls.CreateFile,
+		"close":      &sysCalls.Close,
+		"lseek":      &sysCalls.Lseek,
+		"renameat":   &sysCalls.RenameAt,
+		"stat":       &sysCalls.Stat,
+		"fstat":      &sysCalls.FStat,
+		"lstat":      &sysCalls.LStat,
+		"fstatat":    &sysCalls.FStatAt,
+		"unlinkat":   &sysCalls.UnlinkAt,
+		"chmod":      &sysCalls.Chmod,
+		"fchmod":     &sysCalls.FChmod,
+		"fchown":     &sysCalls.FChown,
+		"lchown":     &sysCalls.LChown,
+		"chdir":      &sysCalls.Chdir,
+		"getwd":      &sysCalls.GetWD,
+		"fntimes":    &sysCalls.Fntimes,
+		"ftruncate":  &sysCalls.FTruncate,
+		"fallocate":  &sysCalls.FAllocate,
+		"readlinkat": &sysCalls.ReadLinkAt,
+		"symlinkat":  &sysCalls.SymLinkAt,
+		"linkat":     &sysCalls.LinkAt,
+		"mknodat":    &sysCalls.MknodAt,
+		"realpath":   &sysCalls.RealPath,
+	}
+}
+
 // LocateSMBStatus finds the local executable of 'smbstatus' on host.
 func LocateSMBStatus() (string, error) {
-	knowns := []string{
-		"/usr/bin/smbstatus",
-	}
+	return locateExecutable("smbstatus", "/usr/bin/smbstatus")
+}
+
+func locateExecutable(name string, knowns ...string) (string, error) {
 	for _, loc := range knowns {
 		fi, err := os.Stat(loc)
 		if err != nil {
@@ -292,12 +417,12 @@ func LocateSMBStatus() (string, error) {
 			return loc, nil
 		}
 	}
-	return "", errors.New("failed to locate smbstatus")
+	return "", fmt.Errorf("failed to locate %s", name)
 }
 
 // RunSMBStatusVersion executes 'smbstatus --version' on host container
-func RunSMBStatusVersion() (string, error) {
-	ver, err := executeSMBStatusCommand("--version")
+func RunSMBStatusVersion(ctx context.Context) (string, error) {
+	ver, err := executeSMBStatusCommand(ctx, "--version")
 	if err != nil {
 		return "", err
 	}
@@ -305,8 +430,8 @@ func RunSMBStatusVersion() (string, error) {
 }
 
 // RunSMBStatusShares executes 'smbstatus --processes --json' on host
-func RunSMBStatusProcesses() (*SMBStatus, error) {
-	dat, err := executeSMBStatusCommand("--processes", "--json")
+func RunSMBStatusProcesses(ctx context.Context) (*SMBStatus, error) {
+	dat, err := executeSMBStatusCommand(ctx, "--processes", "--json")
 	if err != nil {
 		return &SMBStatus{}, err
 	}
@@ -314,8 +439,8 @@ func RunSMBStatusProcesses() (*SMBStatus, error) {
 }
 
 // RunSMBStatusShares executes 'smbstatus --shares --json' on host
-func RunSMBStatusShares() (*SMBStatus, error) {
-	dat, err := executeSMBStatusCommand("--shares", "--json")
+func RunSMBStatusShares(ctx context.Context) (*SMBStatus, error) {
+	dat, err := executeSMBStatusCommand(ctx, "--shares", "--json")
 	if err != nil {
 		return &SMBStatus{}, err
 	}
@@ -323,8 +448,8 @@ func RunSMBStatusShares() (*SMBStatus, error) {
 }
 
 // RunSMBStatusLocks executes 'smbstatus --locks --json' on host
-func RunSMBStatusLocks() ([]SMBStatusOpenFile, error) {
-	dat, err := executeSMBStatusCommand("--locks", "--json")
+func RunSMBStatusLocks(ctx context.Context) ([]SMBStatusOpenFile, error) {
+	dat, err := executeSMBStatusCommand(ctx, "--locks", "--json")
 	if err != nil {
 		return []SMBStatusOpenFile{}, err
 	}
@@ -337,15 +462,20 @@ func parseSMBStatusLockedFiles(dat string) ([]SMBStatusOpenFile, error) {
 	if err != nil {
 		return lockedFiles, err
 	}
-	for _, lfile := range res.OpenFiles {
-		lockedFiles = append(lockedFiles, lfile)
+	keys := make([]string, 0, len(res.OpenFiles))
+	for key := range res.OpenFiles {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		lockedFiles = append(lockedFiles, res.OpenFiles[key])
 	}
 	return lockedFiles, nil
 }
 
 // RunSMBStatusProfile executes 'smbstatus --profile --json' on host
-func RunSMBStatusProfile() (*SMBProfile, error) {
-	dat, err := executeSMBStatusCommand("--profile", "--json")
+func RunSMBStatusProfile(ctx context.Context) (*SMBProfile, error) {
+	dat, err := executeSMBStatusCommand(ctx, "--profile", "--json")
 	if err != nil {
 		return &SMBProfile{}, err
 	}
@@ -354,8 +484,8 @@ func RunSMBStatusProfile() (*SMBProfile, error) {
 
 // SMBStatusSharesByMachine converts the output of RunSMBStatusShares into map
 // indexed by machine's host
-func SMBStatusSharesByMachine() (map[string][]SMBStatusTreeCon, error) {
-	smbstat, err := RunSMBStatusShares()
+func SMBStatusSharesByMachine(ctx context.Context) (map[string][]SMBStatusTreeCon, error) {
+	smbstat, err := RunSMBStatusShares(ctx)
 	if err != nil {
 		return map[string][]SMBStatusTreeCon{}, err
 	}
@@ -370,16 +500,16 @@ func makeSmbSharesMap(tcons []SMBStatusTreeCon) map[string][]SMBStatusTreeCon {
 	return ret
 }
 
-func executeSMBStatusCommand(args ...string) (string, error) {
+func executeSMBStatusCommand(ctx context.Context, args ...string) (string, error) {
 	loc, err := LocateSMBStatus()
 	if err != nil {
 		return "", err
 	}
-	return executeCommand(loc, args...)
+	return executeCommand(ctx, loc, args...)
 }
 
-func executeCommand(command string, arg ...string) (string, error) {
-	cmd := exec.Command(command, arg...)
+func executeCommand(ctx context.Context, command string, arg ...string) (string, error) {
+	cmd := exec.CommandContext(ctx, command, arg...)
 	out, err := cmd.Output()
 	if err != nil {
 		return string(out), err
@@ -452,21 +582,65 @@ func (smbstat *SMBStatus) ListTreeCons() []SMBStatusTreeCon {
 	return tcons
 }
 
+// SMBProfileExtendedKey represents the parts of an 'Extended Profile' key,
+// which has the form 'share:pid.idx[client]'
+type SMBProfileExtendedKey struct {
+	// Share is the name of share (which may contain colons)
+	Share string
+	// PID is the process id of smbd which serves the connection
+	PID string
+	// Index is the connection index within smbd process
+	Index string
+	// Client is the remote address (IPv4, IPv6 or host name) of client
+	Client string
+}
+
+// ParseSMBProfileExtendedKey parses an extended profile key. The key is
+// parsed right to left, as both share name and (IPv6) client address may
+// contain colons.
+func ParseSMBProfileExtendedKey(key string) (*SMBProfileExtendedKey, error) {
+	open := strings.LastIndex(key, "[")
+	if open < 0 || !strings.HasSuffix(key, "]") {
+		return nil, fmt.Errorf("missing client in extended profile key: %q", key)
+	}
+	client := key[open+1 : len(key)-1]
+	sep := strings.LastIndex(key[:open], ":")
+	if sep < 0 {
+		return nil, fmt.Errorf("missing share in extended profile key: %q", key)
+	}
+	share := key[:sep]
+	pid, index, found := strings.Cut(key[sep+1:open], ".")
+	if share == "" || client == "" || !found ||
+		!isDecimal(pid) || !isDecimal(index) {
+		return nil, fmt.Errorf("malformed extended profile key: %q", key)
+	}
+	return &SMBProfileExtendedKey{
+		Share:  share,
+		PID:    pid,
+		Index:  index,
+		Client: client,
+	}, nil
+}
+
 // ParseExtendedProfileKey parse the extended profile key into a pair of
 // share-name and client-ip as string. Returns a pair of empty strings in case
 // of parse failure.
 func ParseExtendedProfileKey(key string) (shareName, clientIP string) {
-	shareName = ""
-	clientIP = ""
-	sp := strings.Split(key, ":")
-	if len(sp) != 2 {
-		return
+	pkey, err := ParseSMBProfileExtendedKey(key)
+	if err != nil {
+		return "", ""
 	}
-	shareName = sp[0]
-	sp = strings.Split(sp[1], "[")
-	if len(sp) != 2 {
-		return
+	return pkey.Share, pkey.Client
+}
+
+func isDecimal(s string) bool {
+	if s == "" {
+		return false
+	}
+	for _, c := range s {
+		if c < '0' || c > '9' {
+			return false
+		}
 	}
-	clientIP = strings.Trim(sp[1], "[]")
-	return
+	return true
 }
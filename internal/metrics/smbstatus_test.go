@@ -64,12 +64,11 @@ func TestParseSMBStatusLocks(t *testing.T) {
 	locks, err := parseSMBStatusLockedFiles(testdata)
 	assert.NoError(t, err)
 	assert.Equal(t, len(locks), 2)
-	lock1 := locks[0]
-	assert.Equal(t, lock1.FileID.Inode, int64(61))
-	assert.Equal(t, lock1.NumPendingDeletes, 0)
-	lock2 := locks[1]
-	assert.Equal(t, lock2.FileID.Inode, int64(52))
-	assert.Equal(t, lock2.NumPendingDeletes, 2)
+	pendingDeletes := map[int64]int{}
+	for _, lock := range locks {
+		pendingDeletes[lock.FileID.Inode] = lock.NumPendingDeletes
+	}
+	assert.Equal(t, pendingDeletes, map[int64]int{61: 0, 52: 2})
 }
 
 func TestParseSMBStatusOpenFiles(t *testing.T) {
@@ -193,3 +192,45 @@ func TestParseSMBStatusProfilePerShare2(t *testing.T) {
 	}
 	assert.Equal(t, numClients, 2)
 }
+
+func TestParseSMBProfileExtendedKey(t *testing.T) {
+	pkey, err := ParseSMBProfileExtendedKey("smbshare1:10949.0[192.168.122.108]")
+	assert.NoError(t, err)
+	assert.Equal(t, pkey.Share, "smbshare1")
+	assert.Equal(t, pkey.PID, "10949")
+	assert.Equal(t, pkey.Index, "0")
+	assert.Equal(t, pkey.Client, "192.168.122.108")
+
+	pkey, err = ParseSMBProfileExtendedKey("share:123.0[fe80::1]")
+	assert.NoError(t, err)
+	assert.Equal(t, pkey.Share, "share")
+	assert.Equal(t, pkey.Client, "fe80::1")
+
+	pkey, err = ParseSMBProfileExtendedKey("Data:Archive:2308.12[client1.example.com]")
+	assert.NoError(t, err)
+	assert.Equal(t, pkey.Share, "Data:Archive")
+	assert.Equal(t, pkey.PID, "2308")
+	assert.Equal(t, pkey.Index, "12")
+	assert.Equal(t, pkey.Client, "client1.example.com")
+
+	shareName, clientIP := ParseExtendedProfileKey("share:123.0[fe80::1]")
+	assert.Equal(t, shareName, "share")
+	assert.Equal(t, clientIP, "fe80::1")
+
+	for _, key := range []string{
+		"",
+		"share",
+		"share:123.0",
+		"share:123.0[]",
+		":123.0[192.168.122.108]",
+		"share:123[192.168.122.108]",
+		"share:abc.0[192.168.122.108]",
+		"share[192.168.122.108]",
+	} {
+		_, err = ParseSMBProfileExtendedKey(key)
+		assert.Error(t, err, key)
+		shareName, clientIP = ParseExtendedProfileKey(key)
+		assert.Empty(t, shareName)
+		assert.Empty(t, clientIP)
+	}
+}
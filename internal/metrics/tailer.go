@@ -0,0 +1,157 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package metrics
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/go-logr/logr"
+)
+
+var (
+	// DefaultTailInterval is the default polling interval of followed log
+	// files
+	DefaultTailInterval = time.Second
+)
+
+// logFollower is implemented by collectors which derive their metrics from
+// events written to log files rather than from on-scrape snapshots
+type logFollower interface {
+	follow(ctx context.Context)
+}
+
+// fileTailer follows a text file line by line, similar to 'tail -F': it
+// polls for appended data and re-opens the file when it is rotated (moved
+// away and re-created) or truncated.
+type fileTailer struct {
+	path     string
+	log      logr.Logger
+	interval time.Duration
+	// fromStart causes the file to be processed from its beginning upon
+	// first open; otherwise, only lines appended afterwards are processed.
+	// Files which appear later on (or re-created upon rotation) are always
+	// processed from their beginning.
+	fromStart bool
+	file      *os.File
+	info      os.FileInfo
+	reader    *bufio.Reader
+	offset    int64
+	partial   string
+}
+
+func newFileTailer(path string, fromStart bool, log logr.Logger) *fileTailer {
+	return &fileTailer{
+		path:      path,
+		log:       log.WithValues("path", path),
+		interval:  DefaultTailInterval,
+		fromStart: fromStart,
+	}
+}
+
+// run follows the file and calls handle for each complete line until ctx
+// is canceled
+func (ft *fileTailer) run(ctx context.Context, handle func(line string)) {
+	ticker := time.NewTicker(ft.interval)
+	defer ticker.Stop()
+	defer ft.close()
+	for {
+		ft.poll(handle)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (ft *fileTailer) poll(handle func(line string)) {
+	if ft.file == nil {
+		if err := ft.open(); err != nil {
+			ft.log.V(1).Info("failed to open followed file", "err", err)
+			return
+		}
+	}
+	ft.readLines(handle)
+	if !ft.rotated() {
+		return
+	}
+	ft.log.V(1).Info("followed file rotated")
+	ft.close()
+	if err := ft.open(); err != nil {
+		return
+	}
+	ft.readLines(handle)
+}
+
+func (ft *fileTailer) open() error {
+	fromStart := ft.fromStart
+	// Any file opened from now on is new and thus read from its beginning
+	ft.fromStart = true
+	file, err := os.Open(ft.path)
+	if err != nil {
+		return err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+	offset := int64(0)
+	if !fromStart {
+		offset, err = file.Seek(0, io.SeekEnd)
+		if err != nil {
+			file.Close()
+			return err
+		}
+	}
+	ft.file = file
+	ft.info = info
+	ft.reader = bufio.NewReader(file)
+	ft.offset = offset
+	ft.partial = ""
+	return nil
+}
+
+func (ft *fileTailer) close() {
+	if ft.file != nil {
+		ft.file.Close()
+	}
+	ft.file = nil
+	ft.info = nil
+	ft.reader = nil
+}
+
+func (ft *fileTailer) readLines(handle func(line string)) {
+	for {
+		dat, err := ft.reader.ReadString('\n')
+		ft.offset += int64(len(dat))
+		if err != nil {
+			// Keep incomplete last line until the rest of it is written
+			ft.partial += dat
+			if !errors.Is(err, io.EOF) {
+				ft.log.Error(err, "failed to read followed file")
+			}
+			return
+		}
+		line := strings.TrimRight(ft.partial+dat, "\r\n")
+		ft.partial = ""
+		handle(line)
+	}
+}
+
+// rotated returns true if the followed path now refers to a different file
+// or if the file was truncated
+func (ft *fileTailer) rotated() bool {
+	info, err := os.Stat(ft.path)
+	if err != nil {
+		// Moved away but not re-created (yet)
+		return false
+	}
+	return !os.SameFile(info, ft.info) || info.Size() < ft.offset
+}
@@ -0,0 +1,67 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package metrics
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/go-logr/logr"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFileTailer(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "log.smbd")
+	lines := []string{}
+	handle := func(line string) {
+		lines = append(lines, line)
+	}
+	appendFile := func(data string) {
+		f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+		assert.NoError(t, err)
+		_, err = f.WriteString(data)
+		assert.NoError(t, err)
+		assert.NoError(t, f.Close())
+	}
+
+	// Pre-existing content is skipped
+	appendFile("old\n")
+	ft := newFileTailer(path, false, logr.Discard())
+	defer ft.close()
+	ft.poll(handle)
+	assert.Empty(t, lines)
+
+	// Incomplete lines are held back until completed
+	appendFile("one\ntw")
+	ft.poll(handle)
+	assert.Equal(t, lines, []string{"one"})
+	appendFile("o\r\n")
+	ft.poll(handle)
+	assert.Equal(t, lines, []string{"one", "two"})
+
+	// Rotation: new file is read from its beginning
+	assert.NoError(t, os.Rename(path, path+".old"))
+	ft.poll(handle)
+	appendFile("three\n")
+	ft.poll(handle)
+	assert.Equal(t, lines, []string{"one", "two", "three"})
+
+	// Truncation
+	assert.NoError(t, os.Truncate(path, 0))
+	appendFile("4\n")
+	ft.poll(handle)
+	assert.Equal(t, lines, []string{"one", "two", "three", "4"})
+}
+
+func TestFileTailerFromStart(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.log")
+	assert.NoError(t, os.WriteFile(path, []byte("a\nb\n"), 0600))
+	lines := []string{}
+	ft := newFileTailer(path, true, logr.Discard())
+	defer ft.close()
+	ft.poll(func(line string) {
+		lines = append(lines, line)
+	})
+	assert.Equal(t, lines, []string{"a", "b"})
+}
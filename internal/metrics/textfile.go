@@ -0,0 +1,75 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package metrics
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	// DefaultTextfileInterval is the default interval between consecutive
+	// writes of metrics in textfile mode
+	DefaultTextfileInterval = 15 * time.Second
+	// DefaultTextfileName is the name of file to which metrics are written
+	// in textfile mode; node_exporter's textfile collector reads only files
+	// with '.prom' suffix
+	DefaultTextfileName = "smbmetrics.prom"
+)
+
+func (sme *smbMetricsExporter) textfilePath() string {
+	return filepath.Join(sme.cfg.TextfileDir, DefaultTextfileName)
+}
+
+// writeTextfile writes the contents of exporter's registry atomically (via
+// temporary file and rename), so that readers never see partial output
+func (sme *smbMetricsExporter) writeTextfile() error {
+	return prometheus.WriteToTextfile(sme.textfilePath(), sme.gatherer())
+}
+
+// runTextfile periodically writes metrics into a file within the configured
+// directory, for node_exporter's textfile collector, instead of serving them
+// over HTTP. It returns upon cancellation of ctx.
+func (sme *smbMetricsExporter) runTextfile(ctx context.Context) error {
+	info, err := os.Stat(sme.cfg.TextfileDir)
+	if err != nil {
+		sme.log.Error(err, "failed to access textfile directory",
+			"dir", sme.cfg.TextfileDir)
+		return err
+	}
+	if !info.IsDir() {
+		err = fmt.Errorf("not a directory: %s", sme.cfg.TextfileDir)
+		sme.log.Error(err, "illegal textfile directory")
+		return err
+	}
+
+	sme.log.Info("write metrics",
+		"path", sme.textfilePath(), "interval", sme.cfg.TextfileInterval)
+	ticker := time.NewTicker(sme.cfg.TextfileInterval)
+	defer ticker.Stop()
+	for {
+		if err := sme.writeTextfile(); err != nil {
+			sme.log.Error(err, "failed to write metrics", "path", sme.textfilePath())
+		}
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return sme.shutdownTextfile()
+		}
+	}
+}
+
+func (sme *smbMetricsExporter) shutdownTextfile() error {
+	sme.log.Info("shutdown", "timeout", sme.cfg.ShutdownTimeout)
+	ctx, cancel := context.WithTimeout(context.Background(), sme.cfg.ShutdownTimeout)
+	defer cancel()
+
+	sme.waitPushers(ctx)
+	sme.cancel()
+	return nil
+}
@@ -0,0 +1,56 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package metrics
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/go-logr/logr"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRunTextfile(t *testing.T) {
+	dir := t.TempDir()
+	sme := newSmbMetricsExporter(t.Context(), logr.Discard(), &ExporterConfig{
+		TextfileDir:      dir,
+		TextfileInterval: time.Hour,
+		ShutdownTimeout:  10 * time.Second,
+	})
+	gauge := prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: collectorName("test", "sessions"),
+		Help: "Test gauge",
+	})
+	gauge.Set(7)
+	sme.reg.MustRegister(gauge)
+
+	// Metrics are written upon start, prior to first interval
+	ctx, cancel := context.WithCancel(t.Context())
+	cancel()
+	err := sme.runTextfile(ctx)
+	assert.NoError(t, err)
+	assert.Error(t, sme.ctx.Err())
+
+	data, err := os.ReadFile(filepath.Join(dir, DefaultTextfileName))
+	assert.NoError(t, err)
+	assert.Contains(t, string(data), "smb_test_sessions 7\n")
+	entries, err := os.ReadDir(dir)
+	assert.NoError(t, err)
+	assert.Len(t, entries, 1)
+}
+
+func TestRunTextfileNotDir(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "file")
+	err := os.WriteFile(path, []byte{}, 0600)
+	assert.NoError(t, err)
+	sme := newSmbMetricsExporter(t.Context(), logr.Discard(), &ExporterConfig{
+		TextfileDir:      path,
+		TextfileInterval: time.Hour,
+	})
+	err = sme.runTextfile(t.Context())
+	assert.Error(t, err)
+}
@@ -0,0 +1,302 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package metrics
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/go-logr/logr"
+)
+
+const (
+	// SMBTopSortBytes orders top view by throughput
+	SMBTopSortBytes = "bytes"
+	// SMBTopSortOps orders top view by rate of SMB2 requests
+	SMBTopSortOps = "ops"
+	// SMBTopSortLatency orders top view by average latency of SMB2 requests
+	SMBTopSortLatency = "latency"
+	// SMBTopSortOpens orders top view by number of open files
+	SMBTopSortOpens = "opens"
+)
+
+var (
+	// DefaultTopInterval is the default refresh interval of top view
+	DefaultTopInterval = 2 * time.Second
+	// SMBTopSortKeys lists the supported sort orders of top view
+	SMBTopSortKeys = []string{
+		SMBTopSortBytes, SMBTopSortOps, SMBTopSortLatency, SMBTopSortOpens,
+	}
+)
+
+// TopConfig holds the user-supplied settings of top view
+type TopConfig struct {
+	// Interval is the time between consecutive refreshes
+	Interval time.Duration
+	// SortBy is the (descending) sort order of entries
+	SortBy string
+	// Limit restricts the number of shown entries; zero shows all
+	Limit int
+	// Iterations is the number of refreshes after which to exit; zero
+	// refreshes until canceled
+	Iterations int
+	// Batch disables clearing of screen between refreshes, for output
+	// which is not a terminal
+	Batch bool
+}
+
+// SMBTopEntry represents the activity of a single client on a share, as shown
+// in top view
+type SMBTopEntry struct {
+	Share          string
+	Client         string
+	OpsPerSecond   float64
+	BytesPerSecond float64
+	// AvgLatency is the average execution time in microseconds of SMB2
+	// requests
+	AvgLatency float64
+	OpenFiles  int
+}
+
+// MakeSMBTopEntries derives the per-share and per-client rates of SMB2
+// requests between two profile snapshots, and joins them with the number of
+// open files. Deltas are derived per extended-profile entry (i.e., per smbd
+// process and connection) before summing them for each pair of share and
+// client, so that a closing connection does not appear as counter reset. When
+// there is no previous snapshot, rates are zero.
+func MakeSMBTopEntries(prev, cur *SMBProfile, interval time.Duration,
+	openFiles map[SMBProfileShareClient]int) []SMBTopEntry {
+	entries := map[SMBProfileShareClient]*SMBTopEntry{}
+	entryOf := func(sc SMBProfileShareClient) *SMBTopEntry {
+		entry, found := entries[sc]
+		if !found {
+			entry = &SMBTopEntry{Share: sc.Share, Client: sc.Client}
+			entries[sc] = entry
+		}
+		return entry
+	}
+	latencySums := map[SMBProfileShareClient]int{}
+	for key, share := range cur.extendedEntries() {
+		pkey, err := ParseSMBProfileExtendedKey(key)
+		if err != nil {
+			continue
+		}
+		sc := SMBProfileShareClient{Share: pkey.Share, Client: pkey.Client}
+		entry := entryOf(sc)
+		if prev == nil || interval <= 0 || share.SMB2Calls == nil {
+			continue
+		}
+		// An entry which is missing from previous snapshot has connected
+		// since then, and all of its requests are within interval
+		prevCalls := &SMBProfileSMB2Calls{}
+		if prevShare, found := prev.Extended[key]; found && prevShare.SMB2Calls != nil {
+			prevCalls = prevShare.SMB2Calls
+		}
+		prevOps := prevCalls.Operations()
+		for op, pce := range share.SMB2Calls.Operations() {
+			ppce := prevOps[op]
+			count, usecs, bytes := deriveDeltas(
+				[]int{ppce.Count, ppce.Time, ppce.Inbytes + ppce.Outbytes},
+				[]int{pce.Count, pce.Time, pce.Inbytes + pce.Outbytes})
+			entry.OpsPerSecond += float64(count)
+			entry.BytesPerSecond += float64(bytes)
+			latencySums[sc] += usecs
+		}
+	}
+	for sc, entry := range entries {
+		if entry.OpsPerSecond > 0 {
+			entry.AvgLatency = float64(latencySums[sc]) / entry.OpsPerSecond
+		}
+		if interval > 0 {
+			entry.OpsPerSecond /= interval.Seconds()
+			entry.BytesPerSecond /= interval.Seconds()
+		}
+	}
+	for sc, count := range openFiles {
+		entryOf(sc).OpenFiles = count
+	}
+	ret := make([]SMBTopEntry, 0, len(entries))
+	for _, entry := range entries {
+		ret = append(ret, *entry)
+	}
+	return ret
+}
+
+// SortSMBTopEntries orders entries by descending value of sort key, and then
+// by share and client
+func SortSMBTopEntries(entries []SMBTopEntry, sortBy string) {
+	valueOf := func(entry *SMBTopEntry) float64 {
+		switch sortBy {
+		case SMBTopSortOps:
+			return entry.OpsPerSecond
+		case SMBTopSortLatency:
+			return entry.AvgLatency
+		case SMBTopSortOpens:
+			return float64(entry.OpenFiles)
+		}
+		return entry.BytesPerSecond
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		vi, vj := valueOf(&entries[i]), valueOf(&entries[j])
+		if vi != vj {
+			return vi > vj
+		}
+		if entries[i].Share != entries[j].Share {
+			return entries[i].Share < entries[j].Share
+		}
+		return entries[i].Client < entries[j].Client
+	})
+}
+
+// smbTopView is a single refresh of top view
+type smbTopView struct {
+	updated   time.Time
+	sessions  int
+	treeCons  int
+	openFiles int
+	entries   []SMBTopEntry
+	errs      []error
+}
+
+// smbTop keeps the previous profile snapshot in order to derive rates upon
+// next refresh
+type smbTop struct {
+	cfg      TopConfig
+	log      logr.Logger
+	prev     *SMBProfile
+	prevTime time.Time
+	// sharePaths maps lower-case share names to their configured paths,
+	// for attribution of open files; resolved once, on best-effort basis
+	sharePaths map[string]string
+}
+
+func (st *smbTop) refresh(ctx context.Context, now time.Time) *smbTopView {
+	view := &smbTopView{updated: now}
+	openFiles := map[SMBProfileShareClient]int{}
+	smbInfo, err := NewUpdatedSMBInfo(ctx, st.log)
+	if err == nil {
+		err = smbInfo.UpdateOpenFiles(ctx)
+	}
+	if err != nil {
+		view.errs = append(view.errs, err)
+	} else {
+		view.sessions = smbInfo.TotalSessions()
+		view.treeCons = smbInfo.TotalTreeCons()
+		view.openFiles = smbInfo.TotalOpenFiles()
+		openFiles = smbInfo.MapShareClientToOpenFiles(st.resolveSharePaths(ctx))
+	}
+
+	var cur *SMBProfile
+	smbProfileInfo, err := NewUpdatedSMBProfileInfo(ctx, st.log)
+	if err != nil {
+		view.errs = append(view.errs, err)
+	} else {
+		cur = smbProfileInfo.profileStatus
+	}
+	view.entries = MakeSMBTopEntries(st.prev, cur, now.Sub(st.prevTime), openFiles)
+	SortSMBTopEntries(view.entries, st.cfg.SortBy)
+	if st.cfg.Limit > 0 && len(view.entries) > st.cfg.Limit {
+		view.entries = view.entries[:st.cfg.Limit]
+	}
+	st.prev, st.prevTime = cur, now
+	return view
+}
+
+func (st *smbTop) resolveSharePaths(ctx context.Context) map[string]string {
+	if st.sharePaths != nil {
+		return st.sharePaths
+	}
+	st.sharePaths = map[string]string{}
+	shares, err := RunSMBConfShares(ctx)
+	if err != nil {
+		st.log.V(1).Info("failed to resolve shares configuration", "err", err)
+	}
+	for i := range shares {
+		st.sharePaths[strings.ToLower(shares[i].Name)] = shares[i].Path()
+	}
+	return st.sharePaths
+}
+
+func (st *smbTop) render(w io.Writer, view *smbTopView) error {
+	if !st.cfg.Batch {
+		// Move cursor home and clear screen
+		fmt.Fprint(w, "\x1b[H\x1b[2J")
+	}
+	fmt.Fprintf(w, "smbmetrics top - %s - sessions: %d, tree-connections: %d, "+
+		"open files: %d\n", view.updated.Format(time.TimeOnly),
+		view.sessions, view.treeCons, view.openFiles)
+	for _, err := range view.errs {
+		fmt.Fprintf(w, "error: %s\n", strings.TrimSpace(err.Error()))
+	}
+	fmt.Fprintln(w)
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "SHARE\tCLIENT\tOPS/S\tBYTES/S\tLATENCY(us)\tOPEN FILES")
+	for _, entry := range view.entries {
+		fmt.Fprintf(tw, "%s\t%s\t%.1f\t%s\t%.1f\t%d\n",
+			entry.Share, entry.Client, entry.OpsPerSecond,
+			formatIECBytes(entry.BytesPerSecond), entry.AvgLatency,
+			entry.OpenFiles)
+	}
+	if st.cfg.Batch {
+		// Separate consecutive refreshes
+		fmt.Fprintln(tw)
+	}
+	return tw.Flush()
+}
+
+// formatIECBytes formats a number of bytes with binary prefix
+func formatIECBytes(bytes float64) string {
+	const unit = 1024
+	if bytes < unit {
+		return fmt.Sprintf("%.0f B", bytes)
+	}
+	exp := 0
+	for n := bytes / unit; n >= unit && exp < 5; n /= unit {
+		exp++
+	}
+	value := bytes / float64(uint64(1)<<(10*(exp+1)))
+	return fmt.Sprintf("%.1f %ciB", value, "KMGTPE"[exp])
+}
+
+// RunSMBTop periodically refreshes SMB status and profile information and
+// writes a table of per-share and per-client activity to w, until ctx is
+// canceled or the configured number of iterations is done. Failures of
+// smbstatus are shown within the table's header, rather than logged.
+func RunSMBTop(ctx context.Context, cfg *TopConfig, w io.Writer) error {
+	found := false
+	for _, key := range SMBTopSortKeys {
+		found = found || key == cfg.SortBy
+	}
+	if !found {
+		return fmt.Errorf("unknown sort key: %s (expected one of: %s)",
+			cfg.SortBy, strings.Join(SMBTopSortKeys, ", "))
+	}
+	if cfg.Interval <= 0 {
+		cfg.Interval = DefaultTopInterval
+	}
+	st := &smbTop{cfg: *cfg, log: logr.Discard()}
+	ticker := time.NewTicker(cfg.Interval)
+	defer ticker.Stop()
+	for i := 0; cfg.Iterations <= 0 || i < cfg.Iterations; i++ {
+		if i > 0 {
+			select {
+			case <-ctx.Done():
+				return nil
+			case <-ticker.C:
+			}
+		}
+		view := st.refresh(ctx, time.Now())
+		if ctx.Err() != nil {
+			return nil
+		}
+		if err := st.render(w, view); err != nil {
+			return err
+		}
+	}
+	return nil
+}
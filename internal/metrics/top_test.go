@@ -0,0 +1,103 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package metrics
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/go-logr/logr"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMakeSMBTopEntries(t *testing.T) {
+	testdata := readTestData(t, "smbstatus-profile-per-share2.json")
+	prevProfile, err := parseSMBProfile(testdata)
+	assert.NoError(t, err)
+	// Same client connects again to same share, with duplicated activity
+	cur := newTestSMBProfileDup(t)
+	client25 := SMBProfileShareClient{Share: "smbshare", Client: "192.168.122.25"}
+	client108 := SMBProfileShareClient{Share: "smbshare", Client: "192.168.122.108"}
+	client83 := SMBProfileShareClient{Share: "smbshare", Client: "192.168.122.83"}
+	openFiles := map[SMBProfileShareClient]int{client25: 3, client83: 1}
+
+	entries := MakeSMBTopEntries(nil, cur, 0, openFiles)
+	assert.Len(t, entries, 3)
+	for _, entry := range entries {
+		assert.Equal(t, entry.OpsPerSecond, float64(0))
+	}
+
+	entries = MakeSMBTopEntries(prevProfile, cur, 10*time.Second, openFiles)
+	assert.Len(t, entries, 3)
+	SortSMBTopEntries(entries, SMBTopSortOps)
+	totals := prevProfile.Extended["smbshare:2308.0[192.168.122.25]"].SMB2Calls.Totals()
+	assert.Equal(t, entries[0].Share, client25.Share)
+	assert.Equal(t, entries[0].Client, client25.Client)
+	assert.InDelta(t, entries[0].OpsPerSecond, float64(totals.Count)/10, 1e-9)
+	assert.InDelta(t, entries[0].BytesPerSecond, float64(totals.Volume())/10, 1e-9)
+	assert.InDelta(t, entries[0].AvgLatency,
+		float64(totals.Time)/float64(totals.Count), 1e-9)
+	assert.Equal(t, entries[0].OpenFiles, 3)
+	// No activity since previous snapshot
+	assert.Equal(t, entries[1].Client, client108.Client)
+	assert.Equal(t, entries[1].OpsPerSecond, float64(0))
+	assert.Equal(t, entries[1].AvgLatency, float64(0))
+	// Open files without profile information
+	assert.Equal(t, entries[2].Client, client83.Client)
+	assert.Equal(t, entries[2].OpenFiles, 1)
+
+	SortSMBTopEntries(entries, SMBTopSortOpens)
+	assert.Equal(t, entries[0].Client, client25.Client)
+	assert.Equal(t, entries[1].Client, client83.Client)
+	assert.Equal(t, entries[2].Client, client108.Client)
+
+	// Second connection of client is closed, which is not a counter reset
+	entries = MakeSMBTopEntries(cur, prevProfile, 10*time.Second, nil)
+	assert.Len(t, entries, 2)
+	for _, entry := range entries {
+		assert.Equal(t, entry.OpsPerSecond, float64(0))
+		assert.Equal(t, entry.BytesPerSecond, float64(0))
+	}
+}
+
+func TestSMBTopRender(t *testing.T) {
+	st := &smbTop{cfg: TopConfig{Batch: true}, log: logr.Discard()}
+	view := &smbTopView{
+		updated:   time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC),
+		sessions:  1,
+		treeCons:  1,
+		openFiles: 2,
+		entries: []SMBTopEntry{{
+			Share:          "smbshare",
+			Client:         "192.168.122.25",
+			OpsPerSecond:   12.5,
+			BytesPerSecond: 3 * 1024 * 1024,
+			AvgLatency:     250,
+			OpenFiles:      2,
+		}},
+	}
+	var buf bytes.Buffer
+	err := st.render(&buf, view)
+	assert.NoError(t, err)
+	assert.Equal(t, buf.String(),
+		"smbmetrics top - 03:04:05 - sessions: 1, tree-connections: 1, open files: 2\n"+
+			"\n"+
+			"SHARE     CLIENT          OPS/S  BYTES/S  LATENCY(us)  OPEN FILES\n"+
+			"smbshare  192.168.122.25  12.5   3.0 MiB  250.0        2\n"+
+			"\n")
+}
+
+func TestFormatIECBytes(t *testing.T) {
+	assert.Equal(t, formatIECBytes(0), "0 B")
+	assert.Equal(t, formatIECBytes(1023), "1023 B")
+	assert.Equal(t, formatIECBytes(1536), "1.5 KiB")
+	assert.Equal(t, formatIECBytes(5*1024*1024*1024), "5.0 GiB")
+}
+
+func TestRunSMBTopUnknownSort(t *testing.T) {
+	var buf bytes.Buffer
+	err := RunSMBTop(t.Context(), &TopConfig{SortBy: "name"}, &buf)
+	assert.Error(t, err)
+	assert.Empty(t, buf.String())
+}
@@ -27,24 +27,24 @@ func UpdateDefaultVersions(version, commitid string) {
 }
 
 // ResolveVersions is a best-effort to resolve current pod's versions info
-func ResolveVersions(clnt *kclient) (Versions, error) {
+func ResolveVersions(ctx context.Context, clnt *kclient) (Versions, error) {
 	var imgErr, smbVersErr, ctdbVersErr error
 	vers := Versions{
 		Version:  defaultVersions.Version,
 		CommitID: defaultVersions.CommitID,
 	}
 	if clnt != nil {
-		vers.SambaImage, imgErr = resolveSambaImage(clnt)
+		vers.SambaImage, imgErr = resolveSambaImage(ctx, clnt)
 	}
-	sambaVersion, smbVersErr := resolveSambaVersion()
+	sambaVersion, smbVersErr := resolveSambaVersion(ctx)
 	vers.SambaVersion = strings.TrimSpace(sambaVersion)
-	ctdbVersion, ctdbVersErr := resolveCtdbVersion()
+	ctdbVersion, ctdbVersErr := resolveCtdbVersion(ctx)
 	vers.CtdbVersion = strings.TrimSpace(ctdbVersion)
 	return vers, errors.Join(imgErr, smbVersErr, ctdbVersErr)
 }
 
-func resolveSambaImage(clnt *kclient) (string, error) {
-	pod, err := GetSelfPod(context.TODO(), clnt)
+func resolveSambaImage(ctx context.Context, clnt *kclient) (string, error) {
+	pod, err := GetSelfPod(ctx, clnt)
 	if err != nil {
 		return "", err
 	}
@@ -56,18 +56,18 @@ func resolveSambaImage(clnt *kclient) (string, error) {
 	return "", nil
 }
 
-func resolveSambaVersion() (string, error) {
-	return executeRpmQCommand("samba")
+func resolveSambaVersion(ctx context.Context) (string, error) {
+	return executeRpmQCommand(ctx, "samba")
 }
 
-func resolveCtdbVersion() (string, error) {
-	return executeRpmQCommand("ctdb")
+func resolveCtdbVersion(ctx context.Context) (string, error) {
+	return executeRpmQCommand(ctx, "ctdb")
 }
 
-func executeRpmQCommand(name string) (string, error) {
-	return executeCommand("rpm", "-q", name)
+func executeRpmQCommand(ctx context.Context, name string) (string, error) {
+	return executeCommand(ctx, "rpm", "-q", name)
 }
 
-func resolveNetbiosName() (string, error) {
-	return executeCommand("net", "conf", "getparm", "global", "netbios name")
+func resolveNetbiosName(ctx context.Context) (string, error) {
+	return executeCommand(ctx, "net", "conf", "getparm", "global", "netbios name")
 }
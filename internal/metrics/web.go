@@ -0,0 +1,172 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package metrics
+
+import (
+	"html/template"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+)
+
+var (
+	// DefaultWebRefreshSeconds is the auto-refresh interval of status page
+	DefaultWebRefreshSeconds = 10
+)
+
+var statusPageTemplate = template.Must(template.New("status").Funcs(
+	template.FuncMap{"join": joinMachines}).Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<meta http-equiv="refresh" content="{{.Refresh}}">
+<title>smbmetrics{{with .NetbiosName}} - {{.}}{{end}}</title>
+<style>
+body { font-family: sans-serif; margin: 2em; }
+table { border-collapse: collapse; margin-bottom: 1.5em; }
+th, td { border: 1px solid #ccc; padding: 0.3em 0.8em; text-align: left; }
+th { background: #eee; }
+.error { color: #a00; }
+</style>
+</head>
+<body>
+<h1>smbmetrics{{with .NetbiosName}} - {{.}}{{end}}</h1>
+<h2>Versions</h2>
+<table>
+<tr><th>Version</th><td>{{.Versions.Version}}</td></tr>
+<tr><th>CommitID</th><td>{{.Versions.CommitID}}</td></tr>
+<tr><th>Samba image</th><td>{{.Versions.SambaImage}}</td></tr>
+<tr><th>Samba version</th><td>{{.Versions.SambaVersion}}</td></tr>
+<tr><th>CTDB version</th><td>{{.Versions.CtdbVersion}}</td></tr>
+</table>
+<h2>Endpoints</h2>
+<ul>
+{{range .Links}}<li><a href="{{.}}">{{.}}</a></li>
+{{end}}</ul>
+{{with .Error}}<p class="error">Failed to query smbstatus: {{.}}</p>{{end}}
+<h2>Clients per share</h2>
+<table>
+<tr><th>Share</th><th>Clients</th><th>Machines</th></tr>
+{{range .Shares}}<tr>
+<td>{{.Name}}</td><td>{{len .Machines}}</td><td>{{join .Machines}}</td>
+</tr>
+{{else}}<tr><td colspan="3">No active shares</td></tr>
+{{end}}</table>
+<h2>Open files</h2>
+<table>
+<tr><th>Share path</th><th>File name</th><th>Opens</th><th>Pending deletes</th></tr>
+{{range .OpenFiles}}<tr>
+<td>{{.ServicePath}}</td><td>{{.Filename}}</td>
+<td>{{len .Opens}}</td><td>{{.NumPendingDeletes}}</td>
+</tr>
+{{else}}<tr><td colspan="4">No open files</td></tr>
+{{end}}</table>
+</body>
+</html>
+`))
+
+type statusPageShare struct {
+	Name     string
+	Machines []string
+}
+
+type statusPage struct {
+	Refresh     int
+	NetbiosName string
+	Versions    Versions
+	Links       []string
+	Error       string
+	Shares      []statusPageShare
+	OpenFiles   []SMBStatusOpenFile
+}
+
+func (sme *smbMetricsExporter) registerWebHandlers() {
+	// Versions do not change during the lifetime of the process, while
+	// resolving them executes commands and queries kubernetes API
+	versions := sync.OnceValue(func() Versions {
+		clnt, _ := newKClient()
+		vers, err := ResolveVersions(sme.ctx, clnt)
+		if err != nil {
+			sme.log.V(1).Info("failed to resolve versions", "err", err)
+		}
+		return vers
+	})
+	sme.mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/" {
+			http.NotFound(w, r)
+			return
+		}
+		sme.serveStatusPage(w, r, versions())
+	})
+}
+
+func (sme *smbMetricsExporter) serveStatusPage(
+	w http.ResponseWriter, r *http.Request, versions Versions) {
+	ctx := r.Context()
+	page := statusPage{
+		Refresh:  DefaultWebRefreshSeconds,
+		Versions: versions,
+		Links:    sme.statusPageLinks(),
+	}
+	page.NetbiosName, _ = resolveNetbiosName(ctx)
+
+	smbInfo, err := NewUpdatedSMBInfo(ctx, sme.log)
+	if err == nil {
+		err = smbInfo.UpdateOpenFiles(ctx)
+	}
+	if err != nil {
+		page.Error = err.Error()
+	} else {
+		page.setSMBInfo(smbInfo)
+	}
+	sme.renderStatusPage(w, &page)
+}
+
+func (page *statusPage) setSMBInfo(smbInfo *SMBInfo) {
+	page.Shares = makeStatusPageShares(smbInfo.MapServiceToMachines())
+	page.OpenFiles = smbInfo.FilterOpenFiles(&SMBInfoFilter{})
+}
+
+func (sme *smbMetricsExporter) renderStatusPage(w http.ResponseWriter, page *statusPage) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	if err := statusPageTemplate.Execute(w, page); err != nil {
+		sme.log.Error(err, "failed to render status page")
+	}
+}
+
+// statusPageLinks lists the endpoints which are linked from status page
+func (sme *smbMetricsExporter) statusPageLinks() []string {
+	links := []string{
+		DefaultMetricsPath,
+		DefaultHealthzPath,
+		DefaultReadyzPath,
+		DefaultAPIPath + "sessions",
+		DefaultAPIPath + "shares",
+		DefaultAPIPath + "openfiles",
+	}
+	if sme.cfg.Profile {
+		links = append(links, DefaultAPIPath+"profile")
+	}
+	return links
+}
+
+func makeStatusPageShares(serviceToMachines map[string]map[string]int) []statusPageShare {
+	shares := []statusPageShare{}
+	for service, machines := range serviceToMachines {
+		share := statusPageShare{Name: service}
+		for machine := range machines {
+			share.Machines = append(share.Machines, machine)
+		}
+		sort.Strings(share.Machines)
+		shares = append(shares, share)
+	}
+	sort.Slice(shares, func(i, j int) bool {
+		return shares[i].Name < shares[j].Name
+	})
+	return shares
+}
+
+func joinMachines(machines []string) string {
+	return strings.Join(machines, ", ")
+}
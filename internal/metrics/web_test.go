@@ -0,0 +1,61 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package metrics
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/go-logr/logr"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRenderStatusPage(t *testing.T) {
+	sme := newSmbMetricsExporter(t.Context(), logr.Discard(), &ExporterConfig{})
+	page := &statusPage{
+		Refresh:     DefaultWebRefreshSeconds,
+		NetbiosName: "SMB-TEST",
+		Links:       sme.statusPageLinks(),
+	}
+	page.setSMBInfo(newTestSMBInfo(t, "smbstatus-openfiles.json"))
+	rec := httptest.NewRecorder()
+	sme.renderStatusPage(rec, page)
+	assert.Equal(t, rec.Code, http.StatusOK)
+	assert.Equal(t, rec.Header().Get("Content-Type"), "text/html; charset=utf-8")
+	body := rec.Body.String()
+	assert.Contains(t, body, "<title>smbmetrics - SMB-TEST</title>")
+	for _, link := range sme.statusPageLinks() {
+		assert.Contains(t, body, `<li><a href="`+link+`">`+link+`</a></li>`)
+	}
+	assert.Contains(t, body,
+		"<td>smbshare</td><td>2</td><td>192.168.122.235, 192.168.122.83</td>")
+	assert.NotContains(t, body, "IPC$")
+	assert.NotContains(t, body, "No active shares")
+}
+
+func TestStatusPageLinks(t *testing.T) {
+	sme := newSmbMetricsExporter(t.Context(), logr.Discard(), &ExporterConfig{})
+	assert.NotContains(t, sme.statusPageLinks(), DefaultAPIPath+"profile")
+	sme = newSmbMetricsExporter(t.Context(), logr.Discard(), &ExporterConfig{Profile: true})
+	assert.Contains(t, sme.statusPageLinks(), DefaultAPIPath+"profile")
+}
+
+func TestStatusPageHandler(t *testing.T) {
+	sme := newSmbMetricsExporter(t.Context(), logr.Discard(), &ExporterConfig{})
+	sme.registerWebHandlers()
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	rec := httptest.NewRecorder()
+	sme.mux.ServeHTTP(rec, req)
+	assert.Equal(t, rec.Code, http.StatusOK)
+	assert.Contains(t, rec.Body.String(),
+		`<li><a href="`+DefaultMetricsPath+`">`+DefaultMetricsPath+`</a></li>`)
+
+	for _, path := range []string{"/index.html", "/api", "/favicon.ico"} {
+		req = httptest.NewRequest(http.MethodGet, path, nil)
+		rec = httptest.NewRecorder()
+		sme.mux.ServeHTTP(rec, req)
+		assert.Equal(t, rec.Code, http.StatusNotFound, path)
+	}
+}
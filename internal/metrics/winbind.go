@@ -0,0 +1,285 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package metrics
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	// DefaultWinbindProbeTimeout is the time limit of the entire set of
+	// 'wbinfo' probes, so that an unresponsive domain controller fails the
+	// remaining probes rather than the entire scrape
+	DefaultWinbindProbeTimeout = 5 * time.Second
+	// DefaultWinbindProbeInterval is the time for which results of 'wbinfo'
+	// probes are reused across scrapes, in order to limit the load on
+	// winbindd and domain controller
+	DefaultWinbindProbeInterval = time.Minute
+
+	wbinfoPingDCRegexp      = regexp.MustCompile(`domain\[([^\]]*)\] dc connection to "([^"]*)"`)
+	wbinfoCheckSecretRegexp = regexp.MustCompile(`trust secret for domain "?([^\s"]+)"?`)
+)
+
+// WbinfoPingDC represents the result of 'wbinfo --ping-dc'
+type WbinfoPingDC struct {
+	Domain   string
+	DC       string
+	Duration time.Duration
+	Err      error
+}
+
+// WbinfoLookup represents the result of a timed 'wbinfo' lookup probe
+type WbinfoLookup struct {
+	Result   string
+	Duration time.Duration
+	Err      error
+}
+
+// LocateWbinfo finds the local executable of 'wbinfo' on host.
+func LocateWbinfo() (string, error) {
+	return locateExecutable("wbinfo", "/usr/bin/wbinfo")
+}
+
+// RunWbinfoPing executes 'wbinfo --ping' on host to check winbindd is alive
+func RunWbinfoPing(ctx context.Context) error {
+	_, err := executeWbinfoCommand(ctx, "--ping")
+	return err
+}
+
+// RunWbinfoOwnDomain executes 'wbinfo --own-domain' on host
+func RunWbinfoOwnDomain(ctx context.Context) (string, error) {
+	return executeWbinfoCommand(ctx, "--own-domain")
+}
+
+// RunWbinfoPingDC executes 'wbinfo --ping-dc' on host. Failure to reach the
+// DC is reported via the Err field of the result.
+func RunWbinfoPingDC(ctx context.Context) *WbinfoPingDC {
+	start := time.Now()
+	dat, err := executeWbinfoCommand(ctx, "--ping-dc")
+	res := &WbinfoPingDC{Duration: time.Since(start), Err: err}
+	res.Domain, res.DC = parseWbinfoPingDC(dat)
+	return res
+}
+
+// RunWbinfoCheckSecret executes 'wbinfo --check-secret' on host, which
+// validates the trust secret of own domain
+func RunWbinfoCheckSecret(ctx context.Context) (string, error) {
+	dat, err := executeWbinfoCommand(ctx, "--check-secret")
+	return parseWbinfoCheckSecret(dat), err
+}
+
+// RunWbinfoOnlineStatus executes 'wbinfo --online-status' on host
+func RunWbinfoOnlineStatus(ctx context.Context) (map[string]bool, error) {
+	dat, err := executeWbinfoCommand(ctx, "--online-status")
+	if err != nil {
+		return map[string]bool{}, err
+	}
+	return parseWbinfoOnlineStatus(dat), nil
+}
+
+// RunWbinfoNameToSID executes timed 'wbinfo --name-to-sid' on host
+func RunWbinfoNameToSID(ctx context.Context, name string) *WbinfoLookup {
+	start := time.Now()
+	dat, err := executeWbinfoCommand(ctx, "--name-to-sid", name)
+	res := &WbinfoLookup{Duration: time.Since(start), Err: err}
+	if err == nil {
+		res.Result, res.Err = parseWbinfoNameToSID(dat)
+	}
+	return res
+}
+
+// RunWbinfoSIDToName executes timed 'wbinfo --sid-to-name' on host
+func RunWbinfoSIDToName(ctx context.Context, sid string) *WbinfoLookup {
+	start := time.Now()
+	dat, err := executeWbinfoCommand(ctx, "--sid-to-name", sid)
+	return &WbinfoLookup{Result: dat, Duration: time.Since(start), Err: err}
+}
+
+func executeWbinfoCommand(ctx context.Context, args ...string) (string, error) {
+	loc, err := LocateWbinfo()
+	if err != nil {
+		return "", err
+	}
+	return executeDeadlineCommand(ctx, loc, args...)
+}
+
+// executeDeadlineCommand executes command and reports expiry of ctx's
+// deadline as error (rather than just a killed process)
+func executeDeadlineCommand(ctx context.Context,
+	command string, arg ...string) (string, error) {
+	dat, err := executeCommand(ctx, command, arg...)
+	if err != nil && errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		return dat, fmt.Errorf("%s timed out: %w",
+			command, context.DeadlineExceeded)
+	}
+	return dat, err
+}
+
+func parseWbinfoPingDC(data string) (domain, dc string) {
+	match := wbinfoPingDCRegexp.FindStringSubmatch(data)
+	if match == nil {
+		return "", ""
+	}
+	return match[1], match[2]
+}
+
+func parseWbinfoCheckSecret(data string) string {
+	match := wbinfoCheckSecretRegexp.FindStringSubmatch(data)
+	if match == nil {
+		return ""
+	}
+	return match[1]
+}
+
+// parseWbinfoOnlineStatus parses lines of the form
+// '<domain> : active connection' or '<domain> : no active connection'
+func parseWbinfoOnlineStatus(data string) map[string]bool {
+	ret := map[string]bool{}
+	for _, line := range strings.Split(data, "\n") {
+		domain, status, found := strings.Cut(line, ":")
+		if !found {
+			continue
+		}
+		domain = strings.TrimSpace(domain)
+		status = strings.TrimSpace(status)
+		ret[domain] = status == "active connection" || status == "online"
+	}
+	return ret
+}
+
+// parseWbinfoNameToSID parses output of the form '<sid> <type> (<num>)'
+func parseWbinfoNameToSID(data string) (string, error) {
+	fields := strings.Fields(data)
+	if len(fields) == 0 || !strings.HasPrefix(fields[0], "S-") {
+		return "", errors.New("malformed wbinfo name-to-sid output")
+	}
+	return fields[0], nil
+}
+
+// winbindProbes holds the results of a single run of 'wbinfo' probes
+type winbindProbes struct {
+	pingDC       *WbinfoPingDC
+	secretDomain string
+	secretErr    error
+	onlineStatus map[string]bool
+	// lookups maps probe names to their results
+	lookups map[string]*WbinfoLookup
+}
+
+type smbWinbindCollector struct {
+	smbCollector
+	mutex   sync.Mutex
+	probes  *winbindProbes
+	updated time.Time
+}
+
+func (col *smbWinbindCollector) Collect(ch chan<- prometheus.Metric) {
+	probes := col.cachedProbes()
+	if probes == nil {
+		return
+	}
+	ch <- prometheus.MustNewConstMetric(col.dsc[0],
+		prometheus.GaugeValue, boolToFloat64(probes.pingDC.Err == nil),
+		probes.pingDC.Domain, probes.pingDC.DC)
+	ch <- prometheus.MustNewConstMetric(col.dsc[1],
+		prometheus.GaugeValue, probes.pingDC.Duration.Seconds(),
+		probes.pingDC.Domain, probes.pingDC.DC)
+	ch <- prometheus.MustNewConstMetric(col.dsc[2],
+		prometheus.GaugeValue, boolToFloat64(probes.secretErr == nil),
+		probes.secretDomain)
+	for domain, online := range probes.onlineStatus {
+		ch <- prometheus.MustNewConstMetric(col.dsc[3],
+			prometheus.GaugeValue, boolToFloat64(online), domain)
+	}
+	for probe, lookup := range probes.lookups {
+		ch <- prometheus.MustNewConstMetric(col.dsc[4],
+			prometheus.GaugeValue, boolToFloat64(lookup.Err == nil), probe)
+		ch <- prometheus.MustNewConstMetric(col.dsc[5],
+			prometheus.GaugeValue, lookup.Duration.Seconds(), probe)
+	}
+}
+
+// cachedProbes returns the results of last run of probes, running them
+// anew once they are older than DefaultWinbindProbeInterval. It returns nil
+// when winbindd is not available.
+func (col *smbWinbindCollector) cachedProbes() *winbindProbes {
+	col.mutex.Lock()
+	defer col.mutex.Unlock()
+	if !col.updated.IsZero() && time.Since(col.updated) < DefaultWinbindProbeInterval {
+		return col.probes
+	}
+	ctx, cancel := context.WithTimeout(col.sme.ctx, DefaultWinbindProbeTimeout)
+	defer cancel()
+	col.probes, col.updated = col.runProbes(ctx), time.Now()
+	return col.probes
+}
+
+func (col *smbWinbindCollector) runProbes(ctx context.Context) *winbindProbes {
+	if _, err := LocateWbinfo(); err != nil {
+		return nil
+	}
+	if err := RunWbinfoPing(ctx); err != nil {
+		// Not a domain member or winbindd not running
+		col.sme.log.V(1).Info("wbinfo ping failed", "err", err)
+		return nil
+	}
+	probes := &winbindProbes{lookups: map[string]*WbinfoLookup{}}
+	probes.pingDC = RunWbinfoPingDC(ctx)
+	probes.secretDomain, probes.secretErr = RunWbinfoCheckSecret(ctx)
+	probes.onlineStatus, _ = RunWbinfoOnlineStatus(ctx)
+
+	name := col.sme.cfg.WinbindProbeName
+	if name == "" {
+		domain, err := RunWbinfoOwnDomain(ctx)
+		if err != nil {
+			return probes
+		}
+		name = domain + `\Domain Users`
+	}
+	nameToSID := RunWbinfoNameToSID(ctx, name)
+	probes.lookups["name_to_sid"] = nameToSID
+	if nameToSID.Err == nil {
+		probes.lookups["sid_to_name"] = RunWbinfoSIDToName(ctx, nameToSID.Result)
+	}
+	return probes
+}
+
+func (sme *smbMetricsExporter) newSMBWinbindCollector() prometheus.Collector {
+	col := &smbWinbindCollector{}
+	col.sme = sme
+	col.dsc = []*prometheus.Desc{
+		prometheus.NewDesc(
+			collectorName("winbind", "dc_connection"),
+			"Whether winbindd has a working connection to domain controller",
+			[]string{"domain", "dc"}, nil),
+		prometheus.NewDesc(
+			collectorName("winbind", "ping_dc_duration_seconds"),
+			"Duration in seconds of winbindd domain controller ping",
+			[]string{"domain", "dc"}, nil),
+		prometheus.NewDesc(
+			collectorName("winbind", "trust_secret_valid"),
+			"Whether the trust secret of own domain is valid",
+			[]string{"domain"}, nil),
+		prometheus.NewDesc(
+			collectorName("winbind", "domain_online"),
+			"Whether winbindd has an active connection to domain",
+			[]string{"domain"}, nil),
+		prometheus.NewDesc(
+			collectorName("winbind", "lookup_success"),
+			"Whether winbindd identity lookup probe succeeded",
+			[]string{"probe"}, nil),
+		prometheus.NewDesc(
+			collectorName("winbind", "lookup_duration_seconds"),
+			"Duration in seconds of winbindd identity lookup probe",
+			[]string{"probe"}, nil),
+	}
+	return col
+}
@@ -0,0 +1,85 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package metrics
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/go-logr/logr"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseWbinfoPingDC(t *testing.T) {
+	domain, dc := parseWbinfoPingDC(
+		`checking the NETLOGON for domain[SAMDOM] dc connection to ` +
+			`"dc1.samdom.example.com" succeeded`)
+	assert.Equal(t, domain, "SAMDOM")
+	assert.Equal(t, dc, "dc1.samdom.example.com")
+
+	domain, dc = parseWbinfoPingDC(
+		"failed to call wbcPingDc: WBC_ERR_DOMAIN_NOT_FOUND\n" +
+			`checking the NETLOGON dc connection to "" failed`)
+	assert.Empty(t, domain)
+	assert.Empty(t, dc)
+}
+
+func TestParseWbinfoCheckSecret(t *testing.T) {
+	domain := parseWbinfoCheckSecret(
+		"checking the trust secret for domain SAMDOM via RPC calls succeeded")
+	assert.Equal(t, domain, "SAMDOM")
+	domain = parseWbinfoCheckSecret(
+		`checking the trust secret for domain "SAMDOM" via RPC calls failed`)
+	assert.Equal(t, domain, "SAMDOM")
+}
+
+func TestParseWbinfoOnlineStatus(t *testing.T) {
+	status := parseWbinfoOnlineStatus(
+		"BUILTIN : active connection\n" +
+			"SMB-TEST : active connection\n" +
+			"SAMDOM : no active connection\n")
+	assert.Equal(t, len(status), 3)
+	assert.True(t, status["BUILTIN"])
+	assert.True(t, status["SMB-TEST"])
+	assert.False(t, status["SAMDOM"])
+}
+
+func TestParseWbinfoNameToSID(t *testing.T) {
+	sid, err := parseWbinfoNameToSID("S-1-5-21-2843290390-2355178906-1853563530-513 " +
+		"SID_DOM_GROUP (2)")
+	assert.NoError(t, err)
+	assert.Equal(t, sid, "S-1-5-21-2843290390-2355178906-1853563530-513")
+	_, err = parseWbinfoNameToSID("")
+	assert.Error(t, err)
+}
+
+func TestExecuteDeadlineCommand(t *testing.T) {
+	ctx, cancel := context.WithTimeout(t.Context(), 100*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	_, err := executeDeadlineCommand(ctx, "sleep", "10")
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+	assert.Less(t, time.Since(start), 5*time.Second)
+
+	dat, err := executeDeadlineCommand(t.Context(), "echo", "ok")
+	assert.NoError(t, err)
+	assert.Equal(t, dat, "ok")
+}
+
+func TestWinbindCollectorCachedProbes(t *testing.T) {
+	sme := newSmbMetricsExporter(t.Context(), logr.Discard(), &ExporterConfig{})
+	col := sme.newSMBWinbindCollector().(*smbWinbindCollector)
+	col.probes = &winbindProbes{
+		pingDC:       &WbinfoPingDC{Domain: "TEST", DC: "dc1.test"},
+		secretDomain: "TEST",
+		onlineStatus: map[string]bool{"TEST": true},
+		lookups: map[string]*WbinfoLookup{
+			"name_to_sid": {Duration: 20 * time.Millisecond},
+		},
+	}
+	col.updated = time.Now()
+	assert.Same(t, col.cachedProbes(), col.probes)
+	assert.Equal(t, testutil.CollectAndCount(col), 6)
+}